@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	flag "github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var infoJSON bool
+
+func printInfoUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("Usage:")
+		fmt.Printf("\t%v info [options] image... (\"-\" for stdin, \"archive.zip|entry\" for zip entry)\n", filepath.Base(os.Args[0]))
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Print(fs.FlagUsages())
+	}
+}
+
+//runInfo print properties and metadata chunks of images
+func runInfo(_ *webpdeep.Options, args []string) {
+	infoFlags := flag.NewFlagSet("infoFlags", flag.ContinueOnError)
+	infoFlags.BoolVar(&infoJSON, "json", false, "print JSON lines")
+	parseFlags(args, printInfoUsage(infoFlags), infoFlags)
+	if cmdFlags.NArg() == 0 {
+		cmdFlags.Usage()
+		os.Exit(1)
+	}
+
+	var (
+		failed bool
+		enc    = json.NewEncoder(os.Stdout)
+	)
+	for _, name := range cmdFlags.Args() {
+		info, err := webpdeep.Inspect(name)
+		if err != nil {
+			failed = true
+			fmt.Fprintf(os.Stderr, "error: <%s>: %v\n", name, err)
+			continue
+		}
+		if infoJSON {
+			_ = enc.Encode(info)
+			continue
+		}
+		alpha := "no alpha"
+		if info.HasAlpha {
+			alpha = "alpha"
+		}
+		fmt.Printf("%s: %s %dx%d %s %d-bit %s, %s\n", info.Path, info.Format, info.Width, info.Height,
+			info.ColorType, info.BitDepth, alpha, webpdeep.FormatBytes(info.Size))
+		meta := make([]string, 0, len(info.Meta))
+		for _, m := range info.Meta {
+			meta = append(meta, fmt.Sprintf("%s %s", m.Name, webpdeep.FormatBytes(int64(m.Size))))
+		}
+		if len(meta) == 0 {
+			meta = append(meta, "none")
+		}
+		fmt.Printf("  meta: %s\n", strings.Join(meta, ", "))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
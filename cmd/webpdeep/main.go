@@ -4,29 +4,42 @@ import "C"
 import (
 	"context"
 	"fmt"
+	"github.com/mattn/go-isatty"
 	"github.com/mocukie/webp-go/webp"
-	"github.com/mocukie/webpdeep/internal/component"
-	"github.com/mocukie/webpdeep/pkg/eventbus"
 	"github.com/mocukie/webpdeep/pkg/imagex/pngx"
+	"github.com/mocukie/webpdeep/pkg/logx"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
 	"github.com/pkg/errors"
 	flag "github.com/spf13/pflag"
 	"golang.org/x/image/bmp"
 	"golang.org/x/image/tiff"
 	"gopkg.in/vrecan/death.v3"
 	"image/jpeg"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"syscall"
 	"time"
 )
 
 var (
-	convertPattern string
-	copyPattern    string
-	archivePattern string
+	output      string
+	logPath     string
+	filesFrom   string
+	base        string
+	zipMem      int64
+	dryRun      string
+	reportPath  string
+	metricsAddr string
+	progress    string
+	logFormat   string
+	logLevel    string
+	logMaxSize  int64
+	logBackups  int
+	quiet       bool
+	verbose     int
 
 	quality        float32
 	preset         string
@@ -42,75 +55,137 @@ var (
 	webpExFlags     *flag.FlagSet
 )
 
-func initConfig() *component.Config {
-	var conf = new(component.Config)
+func initOptions() *webpdeep.Options {
+	var opts = webpdeep.DefaultOptions()
 	configFlags = flag.NewFlagSet("configFlags", flag.ContinueOnError)
-	configFlags.BoolVarP(&conf.Recursively, "recursive", "r", false, "scan input directory recursively")
-	configFlags.StringVarP(&convertPattern, "pattern", "p", "*.png|*.jpg|*.bmp|*.tiff", "convert glob pattern in batch mode")
-	configFlags.StringVar(&copyPattern, "copy", "", "copy glob pattern in batch mode")
+	configFlags.BoolVarP(&opts.Recursive, "recursive", "r", false, "scan input directory recursively")
+	configFlags.StringVar(&opts.Symlinks, "symlinks", opts.Symlinks, "symlink handling in directory walk, one of: files, follow, skip, preserve")
+	configFlags.StringVarP(&opts.ConvertPattern, "pattern", "p", opts.ConvertPattern, "convert glob pattern in batch mode")
+	configFlags.StringVar(&opts.CopyPattern, "copy", "", "copy glob pattern in batch mode")
 	configFlags.Lookup("copy").NoOptDefVal = "*"
-	configFlags.StringVar(&archivePattern, "archive", "*.zip|*.cbz", "archive glob pattern in batch mode")
-	configFlags.BoolVar(&conf.CopyFileMeta, "file_meta", false, "copy file metadata")
-	configFlags.BoolVar(&conf.CopyImageMeta, "image_meta", false, "copy image metadata")
-	configFlags.BoolVar(&conf.CheckImage, "check_image", false, "check output image in lossless mode")
-	configFlags.IntVar(&conf.MaxGo, "max_go", runtime.NumCPU(), "max thread number")
-	configFlags.StringVarP(&conf.Dest, "output", "o", "", "output path, can be omitted in single image mode")
-	configFlags.StringVar(&conf.LogPath, "log", "", "log file path")
+	configFlags.StringVar(&opts.ArchivePattern, "archive", opts.ArchivePattern, "archive glob pattern in batch mode")
+	configFlags.StringArrayVar(&opts.Filters, "filter", nil,
+		"convert only images passed all filters \"key op value\", key is one of: size, mtime, width, height, depth, color, alpha, "+
+			"e.g. \"width>=64\", \"size<50M\", \"alpha=true\", others follow copy pattern")
+	configFlags.BoolVar(&opts.CopyFileMeta, "file_meta", false, "copy file metadata")
+	configFlags.BoolVar(&opts.CopyImageMeta, "image_meta", false, "copy image metadata")
+	addMetaFlags(configFlags, &opts)
+	configFlags.StringVar(&opts.StampSidecar, "stamp_sidecar", "",
+		"name of JSON stamp file looked up in source directories, e.g. .webpdeep.json, nearer directories override")
+	configFlags.BoolVar(&opts.CheckImage, "check_image", false, "check output image in lossless mode")
+	configFlags.IntVar(&opts.Workers, "max_go", opts.Workers, "max thread number")
+	configFlags.StringVarP(&output, "output", "o", "", "output path, can be omitted in single image mode")
+	configFlags.StringVar(&logPath, "log", "", "log file path, or directory of timestamped log file, default is output directory or working directory in place mode")
+	configFlags.StringVar(&logFormat, "log_format", "text", "log format: text or json")
+	configFlags.StringVar(&logLevel, "log_level", "info", "log level: debug, info, warn or error")
+	configFlags.Int64Var(&logMaxSize, "log_max_size", 0, "rotate log file after it exceeds the size in MiB, 0 for never")
+	configFlags.IntVar(&logBackups, "log_backups", 3, "number of rotated log files to keep")
+	configFlags.StringVar(&progress, "progress", "auto", "console progress: auto, tty, plain (periodic lines, errors listed at the end) or none")
+	configFlags.BoolVarP(&quiet, "quiet", "Q", false, "do not echo errors and summary to console, -q is quality")
+	configFlags.CountVarP(&verbose, "verbose", "V", "also echo warnings to console, -v is version")
+	configFlags.StringVar(&reportPath, "report", "", "write JSON lines report of every job and a summary to file")
+	configFlags.StringVar(&metricsAddr, "metrics_addr", "", "serve Prometheus metrics at http://<addr>/metrics, e.g. 127.0.0.1:9090 (also --metrics-addr)")
+	configFlags.StringVar(&filesFrom, "files_from", "", "read input paths from file, newline or NUL separated, \"-\" for stdin (also --files-from)")
+	configFlags.StringVar(&base, "base", ".", "base directory of listed inputs, output keeps their path relative to it")
+	configFlags.StringArrayVar(&opts.ZipMethods, "zip_method", opts.ZipMethods,
+		"zip entry compression rule \"pattern=store\" or \"pattern=deflate[:level]\", first matched wins, then \""+webpdeep.DefaultZipMethod+"\", deflate if none matched")
+	configFlags.BoolVar(&opts.InPlace, "in_place", false, "convert in place, output is written next to the source")
+	configFlags.StringVar(&opts.Original, "original", opts.Original, "source handling after converted in place, one of: keep, delete, backup")
+	configFlags.StringVar(&opts.BackupDir, "backup_dir", "", "directory to move sources into with \"--original backup\", keeps their relative path")
+	configFlags.BoolVar(&opts.Verify, "verify", false, "decode written webp before handling the source in place mode")
+	configFlags.StringVar(&opts.ZipCharset, "zip_charset", opts.ZipCharset, "charset of non-utf8 zip entry names, one of: auto, shift_jis, gbk, big5, cp437, euc-kr (also --zip-charset)")
+	configFlags.StringVar(&opts.Container, "container", opts.Container, "output container of directory or archive input, one of: auto, zip, dir")
+	configFlags.StringVar(&opts.PackExt, "pack_ext", opts.PackExt, "archive extension of packed sub directories in recursive zip container mode")
+	configFlags.BoolVar(&opts.Dedup, "dedup", false, "encode identical inputs once, others reuse the output or become hardlinks")
+	configFlags.StringVar(&opts.DedupCache, "dedup_cache", "", "file to persist dedup cache across runs, implies --dedup")
+	configFlags.BoolVar(&opts.Update, "update", false, "skip sources whose output exists and is not older than them")
+	configFlags.StringVar(&dryRun, "dry_run", "", "print plan of jobs without running them, format is one of: text, json (also --dry-run)")
+	configFlags.Lookup("dry_run").NoOptDefVal = "text"
+	configFlags.BoolVar(&opts.Watch, "watch", false, "keep converting new and changed files of input directory until interrupted")
+	configFlags.DurationVar(&opts.WatchDelay, "watch_delay", opts.WatchDelay, "time a file must stay unchanged before converting in watch mode")
+	configFlags.StringVar(&opts.TempDir, "temp_dir", opts.TempDir, "directory for spilled zip entries")
+	configFlags.Int64Var(&zipMem, "zip_mem", opts.ZipMemLimit>>20, "memory budget (MiB) for pending zip entries before spilling to temp_dir")
 	configFlags.SortFlags = false
-	return conf
+	return &opts
 }
 
-func setupConfig(conf *component.Config) error {
-	var err error
+//addMetaFlags add flags of metadata policy used with --image_meta
+func addMetaFlags(fs *flag.FlagSet, opts *webpdeep.Options) {
+	fs.StringSliceVar(&opts.MetaChunks, "meta_chunks", opts.MetaChunks, "metadata chunks copied with image_meta, any of: iccp, exif, xmp")
+	fs.StringSliceVar(&opts.StripEXIF, "strip_exif", nil,
+		"EXIF tags removed from copied metadata, any of: gps, makernote, serial, thumbnail or tag number such as 0x927c")
+	fs.StringSliceVar(&opts.StripXMP, "strip_xmp", nil, "glob patterns of XMP properties removed from copied metadata, e.g. \"exif:GPS*\",\"aux:SerialNumber\"")
+	fs.StringVar(&opts.Stamp.Artist, "artist", "", "EXIF Artist and XMP dc:creator written into output, overrides source")
+	fs.StringVar(&opts.Stamp.Copyright, "copyright", "", "EXIF Copyright and XMP dc:rights written into output, overrides source")
+	fs.StringVar(&opts.Stamp.Description, "description", "", "EXIF ImageDescription and XMP dc:description written into output, overrides source")
+	fs.StringVar(&opts.Stamp.License, "license_url", "", "XMP xmpRights:WebStatement written into output")
+	fs.StringVar(&opts.Stamp.UsageTerms, "usage_terms", "", "XMP xmpRights:UsageTerms written into output")
+	fs.StringVar(&opts.StampFile, "stamp", "",
+		"JSON file of artist, copyright, description, license and usage_terms written into output, overridden by the flags above")
+}
 
-	conf.ConvertMatch, err = component.NewGlobMatcher(convertPattern)
-	if err != nil {
-		return errors.WithMessage(err, "invalid convert pattern: "+convertPattern)
+//setupOptions apply flags not bound to options
+func setupOptions(opts *webpdeep.Options) error {
+	if zipMem < 0 {
+		return errors.New("invalid zip_mem: " + strconv.FormatInt(zipMem, 10))
 	}
-
-	if copyPattern != "" {
-		conf.CopyMatch, err = component.NewGlobMatcher(copyPattern)
-		if err != nil {
-			return errors.WithMessage(err, "invalid copy pattern: "+convertPattern)
+	opts.ZipMemLimit = zipMem << 20
+	opts.Dedup = opts.Dedup || opts.DedupCache != ""
+
+	switch dryRun {
+	case "":
+	case "text", "json":
+		if opts.Watch {
+			return errors.New("dry_run can not be used with watch")
 		}
+	default:
+		return errors.New("invalid dry_run format: " + dryRun)
 	}
 
-	conf.ArchiveMatch, err = component.NewGlobMatcher(archivePattern)
-	if err != nil {
-		return errors.WithMessage(err, "invalid archive pattern: "+archivePattern)
-	}
-
-	if conf.MaxGo <= 0 {
-		conf.MaxGo = runtime.NumCPU()
+	switch progress {
+	case "auto", "tty", "plain", "none":
+	default:
+		return errors.New("invalid progress: " + progress)
 	}
-
-	conf.Src = cmdFlags.Arg(0)
-	if conf.Src == "" {
-		return errors.New("input not specify")
+	if quiet && verbose > 0 {
+		return errors.New("quiet can not be used with verbose")
 	}
+	return nil
+}
 
-	conf.Src = filepath.Clean(conf.Src)
-	stat, err := os.Stat(conf.Src)
-
-	if !cmdFlags.Lookup("output").Changed {
-		if err == nil && !stat.IsDir() {
-			conf.Dest = conf.Src[:len(conf.Src)-len(filepath.Ext(conf.Src))] + ".webp"
-		} else {
-			return errors.New("output not specify")
+//consoleMode return progress and verbosity of monitor
+func consoleMode() (Progress, int) {
+	var mode Progress
+	switch progress {
+	case "auto":
+		mode = ProgressPlain
+		if quiet {
+			mode = ProgressNone
+		} else if isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd()) {
+			mode = ProgressTTY
 		}
+	case "tty":
+		mode = ProgressTTY
+	case "plain":
+		mode = ProgressPlain
+	case "none":
+		mode = ProgressNone
 	}
-	conf.Dest = filepath.Clean(conf.Dest)
-
-	if err == nil && !cmdFlags.Lookup("log").Changed {
-		if stat.IsDir() {
-			conf.LogPath = conf.Dest
-		} else {
-			conf.LogPath = filepath.Dir(conf.Dest)
-		}
+	switch {
+	case quiet:
+		return mode, VerbosityQuiet
+	case verbose > 0:
+		return mode, VerbosityVerbose
 	}
-	conf.LogPath = filepath.Clean(conf.LogPath)
+	return mode, VerbosityNormal
+}
 
-	return nil
+//newTask resolve task of arguments, multiple inputs or files_from map to output relative to base
+func newTask(conv *webpdeep.Converter) (*webpdeep.Task, error) {
+	if cmdFlags.NArg() > 1 || filesFrom != "" {
+		return conv.NewListTask(base, cmdFlags.Args(), filesFrom, output)
+	}
+	return conv.NewTask(cmdFlags.Arg(0), output)
 }
 
 func initEncodeOption() (*webp.EncodeOptions, error) {
@@ -247,7 +322,19 @@ func printBanner() {
 func printUsage() {
 	printBanner()
 	fmt.Println("Usage:")
-	fmt.Printf("\t%v [options] /path/to/image/or/archive/or/dir -o out/file/or/dir\n", filepath.Base(os.Args[0]))
+	fmt.Printf("\t%v [convert] [options] /path/to/image/or/archive/or/dir -o out/file/or/dir\n", filepath.Base(os.Args[0]))
+	fmt.Printf("\t%v [options] --base /path/to/base /path/to/input... -o out/dir\n", filepath.Base(os.Args[0]))
+	fmt.Printf("\tfind /path/to/base -name '*.png' -print0 | %v [options] --files_from - --base /path/to/base -o out/dir\n", filepath.Base(os.Args[0]))
+	fmt.Printf("\tcurl https://host/image.png | %v [options] - -o - > image.webp\n", filepath.Base(os.Args[0]))
+	fmt.Println()
+
+	fmt.Println("Commands:")
+	fmt.Println("\tconvert  convert images, archives and directories, the default")
+	fmt.Println("\tinfo     print format, dimensions, alpha, bit depth and metadata chunks of images")
+	fmt.Println("\tverify   decode existing outputs and compare them with sources")
+	fmt.Println("\tstats    summarize size savings of existing outputs")
+	fmt.Println("\tserve    convert images and archives over HTTP")
+	fmt.Printf("\tsee %v <command> --help\n", filepath.Base(os.Args[0]))
 	fmt.Println()
 
 	fmt.Println("Options:")
@@ -263,73 +350,272 @@ func printUsage() {
 	fmt.Print(webpExFlags.FlagUsages())
 }
 
+//commands run with options and arguments after command name, convert is the default
+var commands = map[string]func(opts *webpdeep.Options, args []string){
+	"convert": runConvert,
+	"info":    runInfo,
+	"verify":  runVerify,
+	"stats":   runStats,
+	"serve":   runServe,
+}
+
 func main() {
 	var err error
 
-	conf := initConfig()
-	conf.Opts, err = initEncodeOption()
+	opts := initOptions()
+	opts.Encode, err = initEncodeOption()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	//parse argument
+	name, args := "convert", os.Args[1:]
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			name, args = args[0], args[1:]
+		}
+	}
+	commands[name](opts, args)
+}
+
+//flagAliases map other spellings of flags to their names
+var flagAliases = map[string]string{
+	"dry-run":      "dry_run",
+	"files-from":   "files_from",
+	"metrics-addr": "metrics_addr",
+	"zip-charset":  "zip_charset",
+}
+
+//normalizeFlag resolve aliases of flag names
+func normalizeFlag(_ *flag.FlagSet, name string) flag.NormalizedName {
+	if alias, ok := flagAliases[name]; ok {
+		name = alias
+	}
+	return flag.NormalizedName(name)
+}
+
+//parseFlags parse args of command with flag sets into cmdFlags, exit on help or error
+func parseFlags(args []string, usage func(), sets ...*flag.FlagSet) {
 	cmdFlags = flag.NewFlagSet("cmdFlags", flag.ContinueOnError)
-	cmdFlags.AddFlagSet(configFlags)
-	cmdFlags.AddFlagSet(webpPresetFlags)
-	cmdFlags.AddFlagSet(webpMainFlags)
-	cmdFlags.AddFlagSet(webpExFlags)
-	version := cmdFlags.BoolP("version", "v", false, "print version")
-	cmdFlags.Usage = printUsage
+	cmdFlags.SetNormalizeFunc(normalizeFlag)
+	for _, fs := range sets {
+		cmdFlags.AddFlagSet(fs)
+	}
+	cmdFlags.Usage = usage
 	cmdFlags.SortFlags = false
-	err = cmdFlags.Parse(os.Args[1:])
+	err := cmdFlags.Parse(args)
 	if err == flag.ErrHelp {
 		os.Exit(0)
 	} else if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+}
+
+//runConvert convert source into output
+func runConvert(opts *webpdeep.Options, args []string) {
+	var err error
+
+	versionFlags := flag.NewFlagSet("versionFlags", flag.ContinueOnError)
+	version := versionFlags.BoolP("version", "v", false, "print version")
+	parseFlags(args, printUsage, configFlags, webpPresetFlags, webpMainFlags, webpExFlags, versionFlags)
 	if *version {
 		fmt.Printf("WebP encoder version: v%v\n", webp.EncoderVersion())
 		os.Exit(0)
 	}
 
-	err = setupConfig(conf)
+	err = setupOptions(opts)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = setupEncodeOptions(conf.Opts)
+	err = setupEncodeOptions(opts.Encode)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err = os.MkdirAll(conf.LogPath, os.ModePerm); err != nil {
-		log.Fatalf("can not make log directory <%s>, %v", conf.LogPath, err)
+	if filesFrom == "" && cmdFlags.NArg() == 1 && (cmdFlags.Arg(0) == "-" || output == "-") {
+		runStdio(opts)
+		return
+	}
+
+	if dryRun != "" {
+		runPlan(opts)
+		return
+	}
+
+	var (
+		monitor *Monitor
+		metrics *Metrics
+	)
+	opts.OnEvent = func(evt webpdeep.Event) {
+		monitor.Handle(evt)
+		if metrics != nil {
+			metrics.Handle(evt)
+		}
 	}
-	conf.LogPath = filepath.Join(conf.LogPath, time.Now().Format("webpdeep-2006-01-02T15.04.05Z07.00.log"))
-	logOut, err := os.Create(conf.LogPath)
+	conv, err := webpdeep.New(*opts)
 	if err != nil {
-		log.Fatalf("can not create log file %v", err)
+		log.Fatal(err)
+	}
+	task, err := newTask(conv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger, logOut, err := openLog(opts, task)
+	if err != nil {
+		log.Fatal(err)
 	}
 	defer logOut.Close()
 
-	conf.JobQueue = make(chan *component.Job, 1024)
+	root := task.Src
+	if root == "" {
+		root = task.Base
+	}
+	mode, verbosity := consoleMode()
+	monitor = NewMonitor(mode, verbosity, opts.Dedup, root, logger)
+
+	if reportPath != "" {
+		reportOut, err := os.Create(reportPath)
+		if err != nil {
+			log.Fatalf("can not create report file %v", err)
+		}
+		defer reportOut.Close()
+		monitor.SetReport(reportOut)
+	}
+
+	var metricsURL string
+	if metricsAddr != "" {
+		metrics = NewMetrics()
+		metrics.SetConverter(conv)
+		addr, err := metrics.Listen(metricsAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		metricsURL = fmt.Sprintf("http://%v/metrics", addr)
+	}
+
+	//in watch mode, stop watching on signal but finish queued jobs
+	ctx, abort := context.WithCancel(context.Background())
+	hook := death.NewDeath(syscall.SIGINT, syscall.SIGTERM)
+	go hook.WaitForDeathWithFunc(abort)
+
+	if mode == ProgressTTY {
+		printBanner()
+	}
+	if metricsURL != "" && !quiet {
+		fmt.Printf("metrics: %s\n", metricsURL)
+	}
+
+	var runErr error
+	go func() {
+		_, runErr = conv.RunTask(ctx, task)
+		monitor.Close()
+	}()
+	monitor.Start()
+	if runErr != nil && runErr != context.Canceled {
+		log.Print(runErr)
+	}
+	if !quiet {
+		fmt.Println("\nDone.")
+	}
+}
+
+//openLog create log file of task, --log is a directory of timestamped file unless it names a file by extension,
+//timestamped file is in output directory by default, or in working directory not to mix with sources in place
+func openLog(opts *webpdeep.Options, task *webpdeep.Task) (*logx.Logger, io.Closer, error) {
+	level, err := logx.ParseLevel(logLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	var enc logx.Encoder
+	switch logFormat {
+	case "text":
+		enc = logx.TextEncoder{}
+	case "json":
+		enc = logx.JSONEncoder{}
+	default:
+		return nil, nil, errors.New("invalid log format: " + logFormat)
+	}
+	if logMaxSize < 0 {
+		return nil, nil, errors.New("invalid log_max_size: " + strconv.FormatInt(logMaxSize, 10))
+	}
+
 	var (
-		eb         = eventbus.New()
-		transfer   = component.NewTransfer(eb, conf)
-		monitor    = component.NewMonitor(eb, conf, logOut)
-		scanner    = component.NewPathScanner(eb, conf)
-		ctx, abort = context.WithCancel(context.Background())
+		changed = cmdFlags.Lookup("log").Changed
+		dir     = filepath.Clean(logPath)
+		name    string
 	)
+	if !changed && opts.InPlace {
+		dir = "."
+	} else if !changed {
+		dir = task.OutDir
+	} else if info, err := os.Stat(dir); filepath.Ext(dir) != "" && (err != nil || !info.IsDir()) {
+		dir, name = filepath.Dir(dir), dir
+	}
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, nil, errors.Wrapf(err, "can not make log directory <%s>", dir)
+	}
+	if name == "" {
+		name = filepath.Join(dir, time.Now().Format("webpdeep-2006-01-02T15.04.05Z07.00.log"))
+	}
+	out, err := logx.NewRotateFile(name, logMaxSize<<20, logBackups)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can not create log file")
+	}
+	task.LogPath = name
+	return logx.New(out, enc, level), out, nil
+}
 
+//runStdio convert single image from or to standard input and output, console output goes to stderr
+func runStdio(opts *webpdeep.Options) {
+	if opts.InPlace || opts.Watch || dryRun != "" || opts.Container != "auto" {
+		log.Fatal("standard input and output can not be used with in_place, watch, dry_run or container option")
+	}
+	conv, err := webpdeep.New(*opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, err := conv.ConvertFile(cmdFlags.Arg(0), output)
+	if res == nil {
+		log.Fatal(err)
+	} else if err != nil {
+		log.Fatalf("error: <%s> -> <%s>: %v", res.Input, res.Output, err)
+	}
+	if verbose > 0 {
+		for _, warn := range res.Warnings {
+			log.Printf("warn: <%s> -> <%s>: %v", res.Input, res.Output, warn)
+		}
+	}
+}
+
+//runPlan scan and print the jobs without running them
+func runPlan(opts *webpdeep.Options) {
+	conv, err := webpdeep.New(*opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	task, err := newTask(conv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, abort := context.WithCancel(context.Background())
 	hook := death.NewDeath(syscall.SIGINT, syscall.SIGTERM)
 	go hook.WaitForDeathWithFunc(abort)
 
-	printBanner()
-	go transfer.Start(ctx)
-	go scanner.Scan(ctx)
-	monitor.Start(ctx)
-	fmt.Println("\nDone.")
+	plan, err := conv.Plan(ctx, task)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dryRun == "json" {
+		err = plan.WriteJSON(os.Stdout)
+	} else {
+		err = plan.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 //register format
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//encode latency buckets in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64 //per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	if i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+//Metrics collect events of converter and expose them in Prometheus text format
+type Metrics struct {
+	mu        sync.Mutex
+	converter *webpdeep.Converter
+
+	queued     map[string]uint64    //by codec
+	jobs       map[[2]string]uint64 //by codec and result
+	inBytes    map[string]uint64
+	outBytes   map[string]uint64
+	deduped    uint64
+	warnings   uint64
+	scannerErr uint64
+	latency    histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		queued:   map[string]uint64{},
+		jobs:     map[[2]string]uint64{},
+		inBytes:  map[string]uint64{},
+		outBytes: map[string]uint64{},
+	}
+}
+
+//SetConverter set converter reporting queue depth and active workers
+func (m *Metrics) SetConverter(c *webpdeep.Converter) {
+	m.mu.Lock()
+	m.converter = c
+	m.mu.Unlock()
+}
+
+//Listen serve metrics on addr in background, return the bound address
+func (m *Metrics) Listen(addr string) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not listen metrics on <%s>", addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() { _ = http.Serve(ln, mux) }()
+	return ln.Addr(), nil
+}
+
+//Handle count event, safe to call from another goroutine than the server
+func (m *Metrics) Handle(evt webpdeep.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch evt.Type {
+	case webpdeep.EventJobQueued:
+		m.queued[evt.Job.Codec]++
+	case webpdeep.EventJobDone:
+		job := evt.Job
+		result := "ok"
+		if job.Err != nil {
+			result = "error"
+		}
+		m.jobs[[2]string{job.Codec, result}]++
+		m.inBytes[job.Codec] += uint64(job.InBytes)
+		m.outBytes[job.Codec] += uint64(job.OutBytes)
+		m.warnings += uint64(len(job.Warnings))
+		if job.Deduped {
+			m.deduped++
+		} else if job.Codec == webpdeep.CodecConvert && job.Err == nil {
+			m.latency.observe(job.Duration.Seconds())
+		}
+	case webpdeep.EventScanError:
+		m.scannerErr++
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WriteText(w)
+}
+
+//WriteText write metrics in Prometheus text exposition format
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		b     strings.Builder
+		codec = func(c string) string { return fmt.Sprintf(`codec="%s"`, c) }
+	)
+	header := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	byCodec := func(name, typ, help string, v map[string]uint64) {
+		header(name, typ, help)
+		for _, k := range sortedKeys(v) {
+			fmt.Fprintf(&b, "%s{%s} %d\n", name, codec(k), v[k])
+		}
+	}
+
+	byCodec("webpdeep_jobs_queued_total", "counter", "Jobs queued by scanner.", m.queued)
+
+	header("webpdeep_jobs_total", "counter", "Finished jobs by codec and result.")
+	keys := make([][2]string, 0, len(m.jobs))
+	for k := range m.jobs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "webpdeep_jobs_total{%s,result=\"%s\"} %d\n", codec(k[0]), k[1], m.jobs[k])
+	}
+
+	byCodec("webpdeep_input_bytes_total", "counter", "Bytes read from inputs.", m.inBytes)
+	byCodec("webpdeep_output_bytes_total", "counter", "Bytes written to outputs.", m.outBytes)
+
+	header("webpdeep_jobs_deduped_total", "counter", "Jobs reused output of identical input.")
+	fmt.Fprintf(&b, "webpdeep_jobs_deduped_total %d\n", m.deduped)
+	header("webpdeep_warnings_total", "counter", "Warnings of finished jobs.")
+	fmt.Fprintf(&b, "webpdeep_warnings_total %d\n", m.warnings)
+	header("webpdeep_scanner_errors_total", "counter", "Errors of scanner.")
+	fmt.Fprintf(&b, "webpdeep_scanner_errors_total %d\n", m.scannerErr)
+
+	header("webpdeep_encode_duration_seconds", "histogram", "Time spent encoding WebP.")
+	var cum uint64
+	for i, le := range latencyBuckets {
+		if m.latency.counts != nil {
+			cum += m.latency.counts[i]
+		}
+		fmt.Fprintf(&b, "webpdeep_encode_duration_seconds_bucket{le=\"%g\"} %d\n", le, cum)
+	}
+	fmt.Fprintf(&b, "webpdeep_encode_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latency.count)
+	fmt.Fprintf(&b, "webpdeep_encode_duration_seconds_sum %g\n", m.latency.sum)
+	fmt.Fprintf(&b, "webpdeep_encode_duration_seconds_count %d\n", m.latency.count)
+
+	var depth, active int
+	if m.converter != nil {
+		depth, active = m.converter.QueueDepth(), m.converter.ActiveWorkers()
+	}
+	header("webpdeep_job_queue_depth", "gauge", "Jobs waiting in queue.")
+	fmt.Fprintf(&b, "webpdeep_job_queue_depth %d\n", depth)
+	header("webpdeep_active_workers", "gauge", "Workers running a job.")
+	fmt.Fprintf(&b, "webpdeep_active_workers %d\n", active)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetricsAfterRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var img bytes.Buffer
+	if err = png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "src")
+	files := map[string][]byte{"a.png": img.Bytes(), "b.png": img.Bytes(), "c.txt": []byte("text")}
+	if err = os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metrics := NewMetrics()
+	opts := webpdeep.DefaultOptions()
+	opts.CopyPattern = "*.txt"
+	opts.Dedup = true
+	opts.Workers = 1
+	opts.OnEvent = metrics.Handle
+	conv, err := webpdeep.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.SetConverter(conv)
+	if _, err = conv.Run(context.Background(), src, filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := metrics.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+
+	got := map[string]string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		got[line[:i]] = line[i+1:]
+	}
+	want := []struct{ name, value string }{
+		{`webpdeep_jobs_queued_total{codec="convert"}`, "2"},
+		{`webpdeep_jobs_queued_total{codec="copy"}`, "1"},
+		{`webpdeep_jobs_total{codec="convert",result="ok"}`, "2"},
+		{`webpdeep_jobs_total{codec="copy",result="ok"}`, "1"},
+		{`webpdeep_input_bytes_total{codec="convert"}`, strconv.Itoa(2 * img.Len())},
+		{`webpdeep_input_bytes_total{codec="copy"}`, "4"},
+		{`webpdeep_output_bytes_total{codec="copy"}`, "4"},
+		{"webpdeep_jobs_deduped_total", "1"},
+		{"webpdeep_warnings_total", "0"},
+		{"webpdeep_scanner_errors_total", "0"},
+		{`webpdeep_encode_duration_seconds_bucket{le="+Inf"}`, "1"},
+		{"webpdeep_encode_duration_seconds_count", "1"},
+		{"webpdeep_job_queue_depth", "0"},
+		{"webpdeep_active_workers", "0"},
+	}
+	for _, w := range want {
+		if got[w.name] != w.value {
+			t.Errorf("%s: got %q, want %q", w.name, got[w.name], w.value)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mattn/go-colorable"
+	"github.com/mocukie/webpdeep/pkg/logx"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	"io"
+	"time"
+)
+
+//Progress decide how Monitor write progress to console
+type Progress int
+
+const (
+	ProgressAuto  Progress = iota //tty if stdout is a terminal, plain otherwise
+	ProgressTTY                   //redraw colored counter in place
+	ProgressPlain                 //periodic progress lines, errors are listed at the end
+	ProgressNone                  //no progress
+)
+
+const (
+	VerbosityQuiet   = -1
+	VerbosityNormal  = 0
+	VerbosityVerbose = 1
+)
+
+type counter struct {
+	v int
+	t int
+}
+
+type Monitor struct {
+	events     chan webpdeep.Event
+	progress   Progress
+	verbosity  int
+	dedup      bool
+	log        *logx.Logger
+	Convert    counter
+	Copy       counter
+	Errs       int
+	Warnings   int
+	Saved      int //encodes saved by dedup
+	report     *reporter
+	stats      *stats
+	jobCount   counter
+	scannerErr int
+	startTime  time.Time
+	problems   []string //errors and warnings echoed at the end in plain mode
+}
+
+//NewMonitor create monitor, progress is never ProgressAuto and root is the source or base directory of stats
+func NewMonitor(progress Progress, verbosity int, dedup bool, root string, logger *logx.Logger) *Monitor {
+	return &Monitor{
+		events:    make(chan webpdeep.Event, 512),
+		progress:  progress,
+		verbosity: verbosity,
+		dedup:     dedup,
+		log:       logger,
+		stats:     newStats(root),
+	}
+}
+
+//Handle queue event for Start, it is the OnEvent of converter
+func (mo *Monitor) Handle(evt webpdeep.Event) {
+	mo.events <- evt
+}
+
+//Close tell Start no more events
+func (mo *Monitor) Close() {
+	close(mo.events)
+}
+
+//SetReport write JSON lines of finished jobs and a final summary to w
+func (mo *Monitor) SetReport(w io.Writer) {
+	mo.report = newReporter(w)
+}
+
+//Start show events until Close called
+func (mo *Monitor) Start() {
+	var (
+		t1s  = time.NewTicker(1 * time.Second)
+		t5s  = time.NewTicker(5 * time.Second)
+		t30s = time.NewTicker(30 * time.Second)
+		tty  = mo.progress == ProgressTTY
+	)
+	mo.startTime = time.Now()
+	if tty {
+		mo.hideCursor()
+	}
+Loop:
+	for {
+		select {
+		case evt, ok := <-mo.events:
+			if !ok {
+				break Loop
+			}
+			mo.precessEvent(evt)
+		case <-t1s.C:
+			mo.updateConsole()
+		case <-t5s.C:
+			if mo.progress == ProgressPlain {
+				mo.printPlain()
+			}
+		case <-t30s.C:
+			mo.logCounter()
+		}
+	}
+	t1s.Stop()
+	t5s.Stop()
+	t30s.Stop()
+	if tty {
+		fmt.Println()
+		mo.showCursor()
+	}
+	mo.logCounter()
+	if mo.progress == ProgressPlain {
+		mo.printPlain()
+		for _, line := range mo.problems {
+			fmt.Println(line)
+		}
+	}
+	summary := mo.stats.summary()
+	if mo.verbosity > VerbosityQuiet {
+		fmt.Print(summary)
+	}
+	mo.stats.log(mo.log)
+	if mo.report != nil {
+		if err := mo.report.finish(time.Since(mo.startTime), mo.scannerErr); err != nil {
+			mo.log.Error("write report failed", logx.F("stage", "monitor"), logx.F("error", err))
+		}
+	}
+}
+
+func (mo *Monitor) precessEvent(evt webpdeep.Event) {
+	switch evt.Type {
+	case webpdeep.EventJobQueued:
+		job := evt.Job
+		switch job.Codec {
+		case webpdeep.CodecCopy:
+			mo.Copy.t++
+		case webpdeep.CodecConvert:
+			mo.Convert.t++
+		}
+		mo.jobCount.v++
+		mo.stats.queue(job)
+	case webpdeep.EventJobDone:
+		mo.jobCount.t++
+		job := evt.Job
+		mo.stats.finish(job)
+		if job.Err != nil {
+			mo.Errs++
+			mo.log.Error("job failed", jobFields(job, logx.F("error", job.Err))...)
+			mo.logStack(job.Err)
+			mo.echo(VerbosityNormal, "error: <%s> -> <%s>: %v", job.Input, job.Output, job.Err)
+		} else {
+			switch job.Codec {
+			case webpdeep.CodecCopy:
+				mo.Copy.v++
+			case webpdeep.CodecConvert:
+				mo.Convert.v++
+			}
+			if job.Deduped {
+				mo.Saved++
+			}
+			mo.log.Debug("job done", jobFields(job)...)
+		}
+		mo.Warnings += len(job.Warnings)
+		if mo.report != nil {
+			mo.report.job(job)
+		}
+		for _, warn := range job.Warnings {
+			mo.log.Warn("job warning", jobFields(job, logx.F("warning", warn))...)
+			mo.echo(VerbosityVerbose, "warn: <%s> -> <%s>: %v", job.Input, job.Output, warn)
+		}
+	case webpdeep.EventScanError:
+		mo.scannerErr++
+		mo.Errs++
+		mo.log.Error("scan failed", logx.F("stage", "scanner"), logx.F("error", evt.Err))
+		mo.logStack(evt.Err)
+		mo.echo(VerbosityNormal, "error: %v", evt.Err)
+	}
+	mo.updateConsole()
+}
+
+func (mo *Monitor) updateConsole() {
+	if mo.progress != ProgressTTY {
+		return
+	}
+	fmt.Print("\r")
+	mo.printCounter()
+}
+
+//echo write error or warning to console if verbosity allows, plain mode defers them to the end
+func (mo *Monitor) echo(level int, format string, a ...interface{}) {
+	if mo.verbosity < level {
+		return
+	}
+	line := fmt.Sprintf(format, a...)
+	switch mo.progress {
+	case ProgressTTY:
+		fmt.Print("\r\x1b[K")
+		fmt.Println(line)
+	case ProgressPlain:
+		mo.problems = append(mo.problems, line)
+	default:
+		fmt.Println(line)
+	}
+}
+
+func (mo *Monitor) printPlain() {
+	fmt.Printf("conv: %d/%d | copy: %d/%d | error: %d | warn: %d%s%s | elapsed: %v\n",
+		mo.Convert.v, mo.Convert.t, mo.Copy.v, mo.Copy.t, mo.Errs, mo.Warnings, mo.dedupCounter(), mo.statsCounter(),
+		time.Since(mo.startTime).Round(time.Millisecond))
+}
+
+func (mo *Monitor) printCounter() {
+	fmt.Printf("\x1b[36mconv\x1b[0m: %d/%d | \x1b[32mcopy\x1b[0m: %d/%d | \x1B[31merror\x1b[0m: %d | \x1b[33mwarn\x1B[0m: %d%s%s | elapsed: %10v",
+		mo.Convert.v, mo.Convert.t, mo.Copy.v, mo.Copy.t, mo.Errs, mo.Warnings, mo.dedupCounter(), mo.statsCounter(), time.Since(mo.startTime))
+}
+
+func (mo Monitor) logCounter() {
+	fields := []logx.Field{
+		logx.F("conv", mo.Convert.v), logx.F("conv_total", mo.Convert.t),
+		logx.F("copy", mo.Copy.v), logx.F("copy_total", mo.Copy.t),
+		logx.F("errors", mo.Errs), logx.F("warnings", mo.Warnings),
+	}
+	if mo.dedup {
+		fields = append(fields, logx.F("dedup", mo.Saved))
+	}
+	mo.log.Info("progress", append(fields, logx.F("elapsed", time.Since(mo.startTime)))...)
+}
+
+//jobFields return fields identify job, followed by extra
+func jobFields(job *webpdeep.Result, extra ...logx.Field) []logx.Field {
+	fields := []logx.Field{
+		logx.F("path", job.Input),
+		logx.F("output", job.Output),
+		logx.F("stage", "transfer"),
+		logx.F("codec", job.Codec),
+		logx.F("duration", job.Duration),
+	}
+	return append(fields, extra...)
+}
+
+//logStack write stack trace of err at debug level
+func (mo *Monitor) logStack(err error) {
+	if mo.log.Enabled(logx.LevelDebug) {
+		mo.log.Debug("stack", logx.F("stack", fmt.Sprintf("%+v", err)))
+	}
+}
+
+func (mo *Monitor) dedupCounter() string {
+	if !mo.dedup {
+		return ""
+	}
+	return fmt.Sprintf(" | dedup: %d", mo.Saved)
+}
+
+func (mo *Monitor) statsCounter() string {
+	return mo.stats.counter(time.Since(mo.startTime), mo.jobCount)
+}
+
+func (mo *Monitor) hideCursor() {
+	fmt.Print("\033[?25l")
+}
+func (mo *Monitor) showCursor() {
+	fmt.Print("\033[?25h")
+}
+
+func init() {
+	b := true
+	colorable.EnableColorsStdout(&b)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	"io"
+	"sort"
+	"time"
+)
+
+const reportSlowest = 10
+
+//JobRecord is the report line of a finished job
+type JobRecord struct {
+	Type     string              `json:"type"`
+	Input    string              `json:"input"`
+	Output   string              `json:"output"`
+	Codec    string              `json:"codec"`
+	InBytes  int64               `json:"in_bytes"`
+	OutBytes int64               `json:"out_bytes"`
+	Ratio    float64             `json:"ratio"`
+	Duration float64             `json:"duration"` //seconds
+	Width    int                 `json:"width,omitempty"`
+	Height   int                 `json:"height,omitempty"`
+	Options  *webp.EncodeOptions `json:"options,omitempty"`
+	Deduped  bool                `json:"deduped,omitempty"`
+	Warnings []string            `json:"warnings,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+//SlowFile is an entry of the slowest jobs in summary
+type SlowFile struct {
+	Input    string  `json:"input"`
+	Duration float64 `json:"duration"`
+}
+
+//SummaryRecord is the last report line
+type SummaryRecord struct {
+	Type          string     `json:"type"`
+	Jobs          int        `json:"jobs"`
+	Converted     int        `json:"converted"`
+	Copied        int        `json:"copied"`
+	Deduped       int        `json:"deduped"`
+	Failed        int        `json:"failed"`
+	ScannerErrors int        `json:"scanner_errors"`
+	Warnings      int        `json:"warnings"`
+	InBytes       int64      `json:"in_bytes"`
+	OutBytes      int64      `json:"out_bytes"`
+	SavedBytes    int64      `json:"saved_bytes"`
+	Elapsed       float64    `json:"elapsed"` //seconds
+	BytesPerSec   float64    `json:"bytes_per_sec"`
+	FilesPerSec   float64    `json:"files_per_sec"`
+	Slowest       []SlowFile `json:"slowest"`
+}
+
+//reporter write JSON lines of jobs and a summary
+type reporter struct {
+	enc     *json.Encoder
+	summary SummaryRecord
+	err     error
+}
+
+func newReporter(w io.Writer) *reporter {
+	return &reporter{
+		enc:     json.NewEncoder(w),
+		summary: SummaryRecord{Type: "summary", Slowest: []SlowFile{}},
+	}
+}
+
+func (r *reporter) write(v interface{}) {
+	if r.err == nil {
+		r.err = r.enc.Encode(v)
+	}
+}
+
+func (r *reporter) job(job *webpdeep.Result) {
+	rec := JobRecord{
+		Type:     "job",
+		Input:    job.Input,
+		Output:   job.Output,
+		Codec:    job.Codec,
+		InBytes:  job.InBytes,
+		OutBytes: job.OutBytes,
+		Duration: job.Duration.Seconds(),
+		Width:    job.Width,
+		Height:   job.Height,
+		Options:  job.Options,
+		Deduped:  job.Deduped,
+	}
+	if rec.InBytes > 0 {
+		rec.Ratio = float64(rec.OutBytes) / float64(rec.InBytes)
+	}
+	for _, w := range job.Warnings {
+		rec.Warnings = append(rec.Warnings, fmt.Sprint(w))
+	}
+
+	s := &r.summary
+	s.Jobs++
+	s.Warnings += len(job.Warnings)
+	if job.Err != nil {
+		rec.Error = fmt.Sprint(job.Err)
+		s.Failed++
+	} else {
+		switch rec.Codec {
+		case webpdeep.CodecConvert:
+			s.Converted++
+		case webpdeep.CodecCopy:
+			s.Copied++
+		}
+		if job.Deduped {
+			s.Deduped++
+		}
+		s.InBytes += rec.InBytes
+		s.OutBytes += rec.OutBytes
+		r.slow(SlowFile{Input: rec.Input, Duration: rec.Duration})
+	}
+	r.write(&rec)
+}
+
+//slow keep the slowest jobs in descending order
+func (r *reporter) slow(f SlowFile) {
+	s := &r.summary
+	if len(s.Slowest) == reportSlowest && s.Slowest[reportSlowest-1].Duration >= f.Duration {
+		return
+	}
+	i := sort.Search(len(s.Slowest), func(i int) bool { return s.Slowest[i].Duration < f.Duration })
+	if len(s.Slowest) < reportSlowest {
+		s.Slowest = append(s.Slowest, SlowFile{})
+	}
+	copy(s.Slowest[i+1:], s.Slowest[i:])
+	s.Slowest[i] = f
+}
+
+func (r *reporter) finish(elapsed time.Duration, scannerErr int) error {
+	s := &r.summary
+	s.ScannerErrors = scannerErr
+	s.SavedBytes = s.InBytes - s.OutBytes
+	s.Elapsed = elapsed.Seconds()
+	if s.Elapsed > 0 {
+		s.BytesPerSec = float64(s.InBytes) / s.Elapsed
+		s.FilesPerSec = float64(s.Jobs) / s.Elapsed
+	}
+	r.write(s)
+	return r.err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	"github.com/pkg/errors"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/vrecan/death.v3"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+var (
+	serveAddr     string
+	serveMaxBody  int64
+	serveMaxUnzip int64
+	serveProfiles string
+	serveFlags    *flag.FlagSet
+)
+
+func initServeOptions(opts *webpdeep.Options) {
+	serveFlags = flag.NewFlagSet("serveFlags", flag.ContinueOnError)
+	serveFlags.StringVar(&serveAddr, "addr", "127.0.0.1:8080", "listen address")
+	serveFlags.IntVar(&opts.Workers, "max_go", opts.Workers, "max concurrent encodes of all requests")
+	serveFlags.Int64Var(&serveMaxBody, "max_body", 64, "max request body size in MiB, 0 for unlimited")
+	serveFlags.Int64Var(&serveMaxUnzip, "max_unzip", 512, "max total uncompressed size of uploaded zip entries in MiB, 0 for unlimited")
+	serveFlags.StringVar(&serveProfiles, "profiles", "", "JSON file of named encode profiles, e.g. {\"thumb\": {\"quality\": 60, \"method\": 6}}")
+	serveFlags.StringVarP(&opts.ConvertPattern, "pattern", "p", opts.ConvertPattern, "convert glob pattern of zip entries")
+	serveFlags.StringArrayVar(&opts.ZipMethods, "zip_method", opts.ZipMethods,
+		"zip entry compression rule \"pattern=store\" or \"pattern=deflate[:level]\", first matched wins, then \""+webpdeep.DefaultZipMethod+"\", deflate if none matched")
+	serveFlags.BoolVar(&opts.CopyImageMeta, "image_meta", false, "copy image metadata by default")
+	addMetaFlags(serveFlags, opts)
+	serveFlags.BoolVar(&opts.CheckImage, "check_image", false, "check output image in lossless mode")
+	serveFlags.SortFlags = false
+}
+
+//loadProfiles read profiles file, values are query parameters in string, number or bool
+func loadProfiles(name string) (map[string]map[string]string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read profiles")
+	}
+	var raw map[string]map[string]interface{}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrapf(err, "invalid profiles file <%s>", name)
+	}
+	profiles := make(map[string]map[string]string, len(raw))
+	for pn, params := range raw {
+		profile := make(map[string]string, len(params))
+		for k, v := range params {
+			profile[k] = fmt.Sprint(v)
+		}
+		profiles[pn] = profile
+	}
+	return profiles, nil
+}
+
+func printServeUsage() {
+	printBanner()
+	fmt.Println("Usage:")
+	fmt.Printf("\t%v serve [options]\n", filepath.Base(os.Args[0]))
+	fmt.Println()
+	fmt.Println("Endpoints:")
+	fmt.Println("\tPOST /convert      image in raw body or multipart file, returns image/webp")
+	fmt.Println("\tPOST /convert/zip  zip in raw body or multipart file, returns zip with images converted")
+	fmt.Println("\tGET  /healthz      status and worker usage")
+	fmt.Println("\tquery parameters: profile, preset, quality, lossless, z, method, exact, near_lossless, alpha_quality, sharp_yuv, meta")
+	fmt.Println()
+
+	fmt.Println("Options:")
+	fmt.Print(serveFlags.FlagUsages())
+	fmt.Println()
+
+	fmt.Println("WebP Default Encode Options:")
+	fmt.Print(webpPresetFlags.FlagUsages())
+	fmt.Print(webpMainFlags.FlagUsages())
+	fmt.Println()
+
+	fmt.Println("WebP Experimental Encode Options:")
+	fmt.Print(webpExFlags.FlagUsages())
+}
+
+//runServe run HTTP conversion server until interrupted
+func runServe(opts *webpdeep.Options, args []string) {
+	initServeOptions(opts)
+	parseFlags(args, printServeUsage, serveFlags, webpPresetFlags, webpMainFlags, webpExFlags)
+	var err error
+	if cmdFlags.NArg() != 0 {
+		log.Fatalf("unexpected argument: %s", cmdFlags.Arg(0))
+	}
+	if err = setupEncodeOptions(opts.Encode); err != nil {
+		log.Fatal(err)
+	}
+	if serveMaxBody < 0 {
+		log.Fatalf("invalid max_body: %d", serveMaxBody)
+	}
+	if serveMaxUnzip < 0 {
+		log.Fatalf("invalid max_unzip: %d", serveMaxUnzip)
+	}
+
+	serverOpts := webpdeep.ServerOptions{MaxBodySize: serveMaxBody << 20, MaxUnzipSize: serveMaxUnzip << 20}
+	if serveProfiles != "" {
+		if serverOpts.Profiles, err = loadProfiles(serveProfiles); err != nil {
+			log.Fatal(err)
+		}
+	}
+	conv, err := webpdeep.New(*opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler, err := webpdeep.NewServer(conv, serverOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", serveAddr)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "can not listen"))
+	}
+	//slow clients can not hold connections before the body is read
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	hook := death.NewDeath(syscall.SIGINT, syscall.SIGTERM)
+	go hook.WaitForDeathWithFunc(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+
+	log.Printf("serving on http://%v", ln.Addr())
+	if err = server.Serve(ln); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
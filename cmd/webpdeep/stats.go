@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/mocukie/webpdeep/pkg/logx"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//sizeStat sum bytes and count of finished jobs
+type sizeStat struct {
+	jobs   int
+	images int
+	in     int64
+	out    int64
+}
+
+func (s *sizeStat) add(job *webpdeep.Result) {
+	s.jobs++
+	if job.Codec == webpdeep.CodecConvert {
+		s.images++
+	}
+	s.in += job.InBytes
+	s.out += job.OutBytes
+}
+
+//ratio of output to input size in percent
+func (s *sizeStat) ratio() float64 {
+	if s.in == 0 {
+		return 0
+	}
+	return float64(s.out) / float64(s.in) * 100
+}
+
+func (s *sizeStat) String() string {
+	return fmt.Sprintf("files: %d | in: %s | out: %s | ratio: %.1f%%", s.jobs, webpdeep.FormatBytes(s.in), webpdeep.FormatBytes(s.out), s.ratio())
+}
+
+//stats track sizes and throughput for Monitor
+type stats struct {
+	root     string
+	queued   int64 //input bytes of all scanned jobs
+	done     int64 //input bytes of finished jobs, failed ones included
+	total    sizeStat
+	byFormat map[string]*sizeStat
+	byGroup  map[string]*sizeStat
+}
+
+func newStats(root string) *stats {
+	return &stats{root: root, byFormat: map[string]*sizeStat{}, byGroup: map[string]*sizeStat{}}
+}
+
+func (st *stats) queue(job *webpdeep.Result) {
+	st.queued += job.Size
+}
+
+func (st *stats) finish(job *webpdeep.Result) {
+	st.done += job.Size
+	if job.Err != nil {
+		return
+	}
+	st.total.add(job)
+	statOf(st.byFormat, formatOf(job.Input)).add(job)
+	statOf(st.byGroup, st.groupOf(job.Input)).add(job)
+}
+
+func statOf(m map[string]*sizeStat, key string) *sizeStat {
+	s, ok := m[key]
+	if !ok {
+		s = &sizeStat{}
+		m[key] = s
+	}
+	return s
+}
+
+func formatOf(pathname string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(pathname), "."))
+	if ext == "" {
+		return "(none)"
+	}
+	return ext
+}
+
+//groupOf return top-level directory or archive of input relative to root
+func (st *stats) groupOf(pathname string) string {
+	outer := pathname
+	if idx := strings.Index(pathname, iox.NestSeparator); idx != -1 {
+		outer = pathname[:idx]
+	}
+	rel, err := filepath.Rel(st.root, outer)
+	switch {
+	case err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)):
+		return outer
+	case rel == ".":
+		return filepath.Base(outer)
+	}
+	if idx := strings.IndexRune(rel, filepath.Separator); idx != -1 {
+		return rel[:idx]
+	}
+	if outer != pathname {
+		return rel
+	}
+	return "."
+}
+
+//speed return input bytes and images per second
+func (st *stats) speed(elapsed time.Duration) (float64, float64) {
+	sec := elapsed.Seconds()
+	if sec <= 0 {
+		return 0, 0
+	}
+	return float64(st.total.in) / sec, float64(st.total.images) / sec
+}
+
+//eta estimate remaining time by bytes, or by jobs if sizes are unknown
+func (st *stats) eta(elapsed time.Duration, jobs counter) time.Duration {
+	var done, remain float64
+	if st.queued > 0 && st.done > 0 {
+		done, remain = float64(st.done), float64(st.queued-st.done)
+	} else {
+		done, remain = float64(jobs.t), float64(jobs.v-jobs.t)
+	}
+	if done <= 0 || remain <= 0 {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / done * remain).Round(time.Second)
+}
+
+func (st *stats) counter(elapsed time.Duration, jobs counter) string {
+	bps, ips := st.speed(elapsed)
+	return fmt.Sprintf(" | ratio: %.1f%% | %.2fMB/s | %.1fimg/s | eta: %v",
+		st.total.ratio(), bps/1024/1024, ips, st.eta(elapsed, jobs))
+}
+
+//summary break down sizes by input format and top-level directory or archive
+func (st *stats) summary() string {
+	var b strings.Builder
+	write := func(title string, m map[string]*sizeStat) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&b, "%s:\n", title)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "  %-24s %v\n", k, m[k])
+		}
+	}
+	fmt.Fprintf(&b, "total: %v | saved: %s\n", &st.total, webpdeep.FormatBytes(st.total.in-st.total.out))
+	write("by format", st.byFormat)
+	write("by directory", st.byGroup)
+	return b.String()
+}
+
+func (s *sizeStat) fields() []logx.Field {
+	return []logx.Field{
+		logx.F("files", s.jobs), logx.F("in_bytes", s.in), logx.F("out_bytes", s.out),
+		logx.F("ratio", fmt.Sprintf("%.1f%%", s.ratio())),
+	}
+}
+
+//log write summary as entries of total, formats and directories
+func (st *stats) log(l *logx.Logger) {
+	l.Info("summary", st.total.fields()...)
+	write := func(key string, m map[string]*sizeStat) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			l.Info("summary", append([]logx.Field{logx.F(key, k)}, m[k].fields()...)...)
+		}
+	}
+	write("format", st.byFormat)
+	write("directory", st.byGroup)
+}
+
+//runStats summarize size savings of existing outputs
+func runStats(opts *webpdeep.Options, args []string) {
+	conv, task, ctx := auditTask(opts, args, printAuditUsage("stats", "summarize size savings of existing outputs"))
+	results, err := conv.Sizes(ctx, task)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	root := task.Src
+	if root == "" {
+		root = task.Base
+	}
+	var (
+		st      = newStats(root)
+		missing int
+	)
+	for _, r := range results {
+		st.finish(r)
+		if r.Err != nil {
+			missing++
+			if verbose > 0 {
+				fmt.Printf("missing: <%s> -> <%s>: %v\n", r.Input, r.Output, r.Err)
+			}
+		}
+	}
+	fmt.Print(st.summary())
+	fmt.Printf("missing: %d\n", missing)
+}
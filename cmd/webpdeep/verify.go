@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/mocukie/webpdeep/pkg/webpdeep"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/vrecan/death.v3"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+//printAuditUsage return usage of command reading an existing src/dest pair
+func printAuditUsage(name, desc string, sets ...*flag.FlagSet) func() {
+	return func() {
+		fmt.Println("Usage:")
+		fmt.Printf("\t%v %s [options] /path/to/source -o /path/to/output\n", filepath.Base(os.Args[0]), name)
+		fmt.Printf("\t%s, options map sources to outputs as convert does\n", desc)
+		fmt.Println()
+
+		fmt.Println("Options:")
+		for _, fs := range sets {
+			fmt.Print(fs.FlagUsages())
+		}
+		fmt.Print(configFlags.FlagUsages())
+		fmt.Println()
+
+		fmt.Println("WebP Encode Options:")
+		fmt.Print(webpPresetFlags.FlagUsages())
+		fmt.Print(webpMainFlags.FlagUsages())
+	}
+}
+
+//auditTask parse arguments as convert does and resolve task of the existing src/dest pair
+func auditTask(opts *webpdeep.Options, args []string, usage func(), sets ...*flag.FlagSet) (*webpdeep.Converter, *webpdeep.Task, context.Context) {
+	parseFlags(args, usage, append(sets, configFlags, webpPresetFlags, webpMainFlags, webpExFlags)...)
+	if err := setupOptions(opts); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupEncodeOptions(opts.Encode); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Watch || dryRun != "" {
+		log.Fatal("watch and dry_run can not be used with existing outputs")
+	}
+	conv, err := webpdeep.New(*opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	task, err := newTask(conv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, abort := context.WithCancel(context.Background())
+	hook := death.NewDeath(syscall.SIGINT, syscall.SIGTERM)
+	go hook.WaitForDeathWithFunc(abort)
+	return conv, task, ctx
+}
+
+//runVerify decode existing outputs and compare them with sources, exit with 1 if any issue found
+func runVerify(opts *webpdeep.Options, args []string) {
+	verifyFlags := flag.NewFlagSet("verifyFlags", flag.ContinueOnError)
+	verifyFlags.Float64Var(&opts.MinPSNR, "min_psnr", 30, "lowest PSNR (dB) of lossy output, 0 to skip, lossless output must equal the source")
+	usage := printAuditUsage("verify", "decode existing outputs and compare them with sources, "+
+		"report missing, orphaned, undecodable and mismatched outputs", verifyFlags)
+	conv, task, ctx := auditTask(opts, args, usage, verifyFlags)
+	report, err := conv.Verify(ctx, task)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := range report.Issues {
+		fmt.Println(report.Issues[i].String())
+	}
+	fmt.Printf("checked: %d | issues: %d\n", report.Checked, len(report.Issues))
+	if len(report.Issues) != 0 {
+		os.Exit(1)
+	}
+}
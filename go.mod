@@ -3,11 +3,14 @@ module github.com/mocukie/webpdeep
 go 1.15
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/karrick/godirwalk v1.16.1
 	github.com/mattn/go-colorable v0.1.8
+	github.com/mattn/go-isatty v0.0.12
 	github.com/mocukie/webp-go v0.0.0-20201027040619-f0826716ddcf
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
+	golang.org/x/text v0.13.0
 	gopkg.in/vrecan/death.v3 v3.0.1
 )
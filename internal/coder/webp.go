@@ -2,8 +2,10 @@ package coder
 
 import (
 	"bufio"
+	"fmt"
 	"github.com/mocukie/webp-go/webp"
 	"github.com/mocukie/webpdeep/pkg/imagex"
+	"github.com/mocukie/webpdeep/pkg/metax"
 	"github.com/pkg/errors"
 	"io"
 	"strings"
@@ -18,7 +20,20 @@ func init() {
 type WebP struct {
 	Opts       *webp.EncodeOptions
 	CopyMeta   bool
+	Meta       *metax.Policy //chunks and fields copied with CopyMeta, nil for all
+	Stamp      *metax.Stamp  //attribution written over copied metadata, also without CopyMeta
 	CheckImage bool
+	Width      int //size of decoded image, set after Convert
+	Height     int
+}
+
+//Profile describe the encode options briefly
+func (wp *WebP) Profile() string {
+	mode := "lossy"
+	if wp.Opts.Lossless {
+		mode = "lossless"
+	}
+	return fmt.Sprintf("%s q=%g m=%d", mode, wp.Opts.Quality, wp.Opts.Method)
 }
 
 func (wp *WebP) Convert(in io.Reader, out io.Writer) (err error, warnings []error) {
@@ -29,6 +44,7 @@ func (wp *WebP) Convert(in io.Reader, out io.Writer) (err error, warnings []erro
 		err = errors.Wrap(e, "[WebP] decode image failed")
 		return
 	}
+	wp.Width, wp.Height = img.Bounds().Dx(), img.Bounds().Dy()
 
 	var webpData []byte
 	if webpData, err = webp.EncodeSlice(img, opts); err != nil {
@@ -45,9 +61,27 @@ func (wp *WebP) Convert(in io.Reader, out io.Writer) (err error, warnings []erro
 		}
 	}
 
-	if wp.CopyMeta && !meta.Empty() {
+	if (wp.CopyMeta && !meta.Empty()) || wp.Stamp != nil {
 		for _, cc := range [...]webp.FourCC{webp.ICCP, webp.EXIF, webp.XMP} {
-			if chunk := meta.Get(strings.TrimRight(string(cc[:4:4]), " ")); chunk != nil {
+			name := strings.TrimRight(string(cc[:4:4]), " ")
+			var chunk []byte
+			if wp.CopyMeta {
+				chunk = meta.Get(name)
+			}
+			if chunk != nil && wp.Meta != nil {
+				if chunk, e = wp.Meta.Apply(name, chunk); e != nil {
+					//unfiltered chunk may leak what the policy removes
+					warnings = append(warnings, errors.Wrapf(e, "[WebP] filter %s failed, chunk dropped", cc))
+					continue
+				}
+			}
+			if wp.Stamp != nil {
+				if chunk, e = wp.Stamp.Apply(name, chunk); e != nil {
+					warnings = append(warnings, errors.Wrapf(e, "[WebP] stamp %s failed, chunk dropped", cc))
+					continue
+				}
+			}
+			if chunk != nil {
 				var tmp []byte
 				tmp, e = webp.SetMetadata(webpData, cc, chunk)
 				if e != nil {
@@ -1,26 +1,72 @@
 package component
 
 import (
+	"archive/zip"
+	"compress/flate"
 	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/pkg/metax"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type PathMatcher func(pathname string, depPlatform bool) bool
 
+//ZipMethod choose compression for zip entries whose name matched
+type ZipMethod struct {
+	Match  PathMatcher
+	Method uint16
+	Level  int
+}
+
+//Container decide the output type of directory and archive source
+type Container int
+
+const (
+	ContainerAuto Container = iota //mirror the source
+	ContainerZip                   //pack directory into archive
+	ContainerDir                   //unpack archive into directory
+)
+
 type Config struct {
 	Src           string
+	Sources       []string //input list, used with FilesFrom instead of Src
+	FilesFrom     string   //file of NUL or newline separated input list, "-" for stdin
+	Base          string   //input list are mapped into Dest relative to Base, also root of BackupDir
 	Dest          string
 	Recursively   bool
+	Symlinks      Symlinks
 	ConvertMatch  PathMatcher
 	CopyMatch     PathMatcher
 	ArchiveMatch  PathMatcher
+	Filter        *Filter //nil to convert every file matched by ConvertMatch
 	CopyFileMeta  bool
 	CopyImageMeta bool
+	MetaPolicy    *metax.Policy //chunks and fields copied with CopyImageMeta, nil for all
+	Stamp         *metax.Stamp  //attribution written into every webp, nil for none
+	StampSidecar  string        //name of stamp file looked up in source directories, empty for none
 	CheckImage    bool
 	MaxGo         int
 	LogPath       string
+	TempDir       string
+	ZipMemLimit   int64
+	ZipMethods    []ZipMethod
+	ZipCharset    encoding.Encoding
+	Container     Container
+	InPlace       bool     //write output next to source, Dest is the same as Src
+	Original      Original //source handling after converted in place
+	BackupDir     string
+	Verify        bool        //decode written webp before handling source in place
+	Update        bool        //skip sources whose output is not older than them
+	Dedup         *DedupCache //nil to encode every input
+	DryRun        bool        //scan only, jobs are planned but never run
+	Watch         bool
+	WatchDelay    time.Duration
+	PackExt       string
 	Opts          *webp.EncodeOptions
 	JobQueue      chan *Job
 }
@@ -50,3 +96,53 @@ func NewGlobMatcher(pattern string) (PathMatcher, error) {
 		return ok
 	}, nil
 }
+
+//ListMode report whether inputs come from Sources and FilesFrom
+func (c *Config) ListMode() bool {
+	return len(c.Sources) != 0 || c.FilesFrom != ""
+}
+
+//NewZipMethod parse rule in form of "pattern=store" or "pattern=deflate[:level]"
+func NewZipMethod(rule string) (ZipMethod, error) {
+	var zm ZipMethod
+	idx := strings.LastIndex(rule, "=")
+	if idx == -1 {
+		return zm, errors.New("missing compression method")
+	}
+
+	var err error
+	if zm.Match, err = NewGlobMatcher(rule[:idx]); err != nil {
+		return zm, err
+	}
+
+	method := strings.SplitN(rule[idx+1:], ":", 2)
+	switch method[0] {
+	case "store":
+		zm.Method = zip.Store
+		if len(method) > 1 {
+			return zm, errors.New("store method does not accept level")
+		}
+	case "deflate":
+		zm.Method = zip.Deflate
+		zm.Level = flate.DefaultCompression
+		if len(method) > 1 {
+			zm.Level, err = strconv.Atoi(method[1])
+			if err != nil || zm.Level < flate.HuffmanOnly || zm.Level > flate.BestCompression {
+				return zm, errors.New("invalid deflate level: " + method[1])
+			}
+		}
+	default:
+		return zm, errors.New("unknown compression method: " + method[0])
+	}
+	return zm, nil
+}
+
+//ZipMethodOf return the compression method and level of zip entry name, deflate by default
+func (c *Config) ZipMethodOf(name string) (uint16, int) {
+	for _, zm := range c.ZipMethods {
+		if zm.Match(name, false) {
+			return zm.Method, zm.Level
+		}
+	}
+	return zip.Deflate, flate.DefaultCompression
+}
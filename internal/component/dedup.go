@@ -0,0 +1,367 @@
+package component
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mocukie/webpdeep/internal/coder"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//DedupCache map hash of input and encode options to the encoded output, optionally persisted across runs.
+//Outputs which are not files, such as zip entries, are kept in store until Release.
+type DedupCache struct {
+	mu      sync.Mutex
+	path    string
+	store   *iox.ZipStore
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	ready  chan struct{} //closed when the first encode finished
+	ok     bool
+	path   string //output file holding the encoded bytes
+	size   int64
+	mtime  int64            //modification time of path in unix nanoseconds, the file is stale if changed
+	data   *iox.EntryBuffer //encoded bytes if output is not a file
+	width  int              //size of the decoded source, reported by jobs reusing the entry
+	height int
+}
+
+//dedupRecord is a line of persisted cache
+type dedupRecord struct {
+	Key    string `json:"key"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	MTime  int64  `json:"mtime"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+//NewDedupCache create cache, entries are loaded from path if it exists, empty path for in-run cache only
+func NewDedupCache(path string, store *iox.ZipStore) (*DedupCache, error) {
+	c := &DedupCache{path: path, store: store, entries: map[string]*dedupEntry{}}
+	if path == "" {
+		return c, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var r dedupRecord
+		if err = dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "invalid dedup cache <%s>", path)
+		}
+		e := &dedupEntry{
+			ready:  make(chan struct{}),
+			ok:     true,
+			path:   r.Path,
+			size:   r.Size,
+			mtime:  r.MTime,
+			width:  r.Width,
+			height: r.Height,
+		}
+		close(e.ready)
+		c.entries[r.Key] = e
+	}
+	return c, nil
+}
+
+//Save write entries backed by output files
+func (c *DedupCache) Save() error {
+	if c.path == "" {
+		return nil
+	}
+	f, err := iox.CreateTemp(c.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var (
+		w   = bufio.NewWriter(f)
+		enc = json.NewEncoder(w)
+	)
+	c.mu.Lock()
+	for key, e := range c.entries {
+		select {
+		case <-e.ready:
+		default:
+			continue
+		}
+		if e.ok && e.path != "" && e.valid() {
+			r := dedupRecord{Key: key, Path: e.path, Size: e.size, MTime: e.mtime, Width: e.width, Height: e.height}
+			if err = enc.Encode(r); err != nil {
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return errors.WithStack(err)
+}
+
+func (e *dedupEntry) valid() bool {
+	if e.data != nil {
+		return true
+	}
+	info, err := os.Stat(e.path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == e.size && info.ModTime().UnixNano() == e.mtime
+}
+
+//acquire return finished entry of key, or nil if caller should encode it then call release
+func (c *DedupCache) acquire(key string) *dedupEntry {
+	for {
+		c.mu.Lock()
+		e, ok := c.entries[key]
+		if !ok {
+			c.entries[key] = &dedupEntry{ready: make(chan struct{})}
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+
+		<-e.ready
+		if e.ok && e.valid() {
+			return e
+		}
+		//failed or stale, encode again
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+//Release drop entries held in store, call it after jobs of a run done
+func (c *DedupCache) Release() error {
+	var err error
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		select {
+		case <-e.ready:
+		default:
+			continue
+		}
+		if e.data != nil {
+			if e2 := e.data.Release(); e2 != nil && err == nil {
+				err = e2
+			}
+			delete(c.entries, key)
+		}
+	}
+	return err
+}
+
+//release finish entry of key with fields of done except ready
+func (c *DedupCache) release(key string, done *dedupEntry) {
+	c.mu.Lock()
+	e := c.entries[key]
+	c.mu.Unlock()
+	e.ok, e.path, e.size, e.mtime, e.data = done.ok, done.path, done.size, done.mtime, done.data
+	e.width, e.height = done.width, done.height
+	close(e.ready)
+}
+
+//run do convert job, reuse the output of identical input encoded before
+func (c *DedupCache) run(job *Job) {
+	wp, ok := job.Codec.(*coder.WebP)
+	if !ok {
+		job.Do()
+		return
+	}
+
+	sum, size, err := hashInput(job.In)
+	if err != nil {
+		job.fail(err)
+		return
+	}
+
+	key := sum + "-" + dedupOptions(wp)
+	if e := c.acquire(key); e != nil {
+		c.reuse(job, e)
+		job.InSize = size
+		return
+	}
+
+	//input is read again by the codec rather than held in memory
+	capture := &captureCodec{Codec: wp, data: c.store.NewBuffer()}
+	job.Codec = capture
+	job.Do()
+	job.Codec = wp
+
+	var (
+		path    string
+		mtime   int64
+		encoded = capture.data
+	)
+	//output is done, a payload failed to keep is only not reused
+	cached := encoded.Close() == nil
+	if fo, ok := job.Out.(*iox.FileOutput); ok && job.Err == nil {
+		//persisted cache may be used in another working directory
+		if path, err = filepath.Abs(fo.Path()); err == nil {
+			if info, e := os.Stat(path); e == nil {
+				mtime = info.ModTime().UnixNano()
+			} else {
+				path = ""
+			}
+		}
+	}
+	if path != "" || job.Err != nil || !cached {
+		_ = encoded.Release()
+		encoded = nil
+	}
+	c.release(key, &dedupEntry{
+		ok:     job.Err == nil && (path != "" || encoded != nil),
+		path:   path,
+		size:   job.OutSize,
+		mtime:  mtime,
+		data:   encoded,
+		width:  wp.Width,
+		height: wp.Height,
+	})
+}
+
+//reuse make output of job from entry, file output becomes a hardlink if possible,
+//but not if file metadata is copied since the link shares it with the other output
+func (c *DedupCache) reuse(job *Job, e *dedupEntry) {
+	job.Deduped = true
+	if wp, ok := job.Codec.(*coder.WebP); ok {
+		wp.Width, wp.Height = e.width, e.height
+	}
+	if fo, ok := job.Out.(*iox.FileOutput); ok && e.path != "" && !job.CopyMeta {
+		if err := linkFile(e.path, fo.Path()); err == nil {
+			job.OutSize = e.size
+			if job.Done != nil {
+				job.Err = job.Done()
+			}
+			return
+		}
+	}
+
+	info, _ := job.In.Info()
+	in, codec := job.In, job.Codec
+	job.In = &cachedInput{path: in.Path(), info: info, entry: e}
+	job.Codec = &coder.Copy{}
+	job.Do()
+	job.In, job.Codec = in, codec
+}
+
+//hashInput return hex sha256 and size of input, which is closed after
+func hashInput(in iox.Input) (string, int64, error) {
+	if err := in.Open(); err != nil {
+		_ = in.Close()
+		return "", 0, errors.WithStack(err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, in)
+	if e := in.Close(); err == nil {
+		err = e
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, errors.WithStack(err)
+}
+
+//cachedInput read encoded bytes of a dedup entry as the input of path
+type cachedInput struct {
+	io.ReadCloser
+	path  string
+	info  os.FileInfo
+	entry *dedupEntry
+}
+
+func (ci *cachedInput) Path() string {
+	return ci.path
+}
+
+func (ci *cachedInput) Info() (os.FileInfo, error) {
+	return ci.info, nil
+}
+
+func (ci *cachedInput) Open() error {
+	var err error
+	if ci.entry.data != nil {
+		ci.ReadCloser, err = ci.entry.data.Open()
+		return err
+	}
+	ci.ReadCloser, err = os.Open(ci.entry.path)
+	return errors.WithStack(err)
+}
+
+func (ci *cachedInput) Close() error {
+	if ci.ReadCloser == nil {
+		return nil
+	}
+	err := ci.ReadCloser.Close()
+	ci.ReadCloser = nil
+	return err
+}
+
+//dedupOptions identify everything affects the encoded output besides input
+func dedupOptions(wp *coder.WebP) string {
+	opts := fmt.Sprintf("%+v|%v", *wp.Opts, wp.CopyMeta)
+	if wp.CopyMeta && wp.Meta != nil {
+		opts += "|" + wp.Meta.String()
+	}
+	if wp.Stamp != nil {
+		//stamps differ by sidecar of each directory
+		opts += fmt.Sprintf("|%+v", *wp.Stamp)
+	}
+	sum := sha256.Sum256([]byte(opts))
+	return hex.EncodeToString(sum[:8])
+}
+
+//linkFile replace dst with a hardlink of src
+func linkFile(src, dst string) error {
+	if src == dst {
+		return nil
+	}
+	tmp := dst + ".link.tmp"
+	_ = os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+//captureCodec keep a copy of encoded bytes
+type captureCodec struct {
+	coder.Codec
+	data *iox.EntryBuffer
+}
+
+func (c *captureCodec) Convert(in io.Reader, out io.Writer) (error, []error) {
+	return c.Codec.Convert(in, io.MultiWriter(out, c.data))
+}
@@ -0,0 +1,186 @@
+package component
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/internal/coder"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupUnreadableInputFinishesZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, "out.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := iox.NewZipWriter(f, 1, iox.NewZipStore(dir, 1<<20))
+	var committed, failed bool
+	zw.SetCommit(func(f bool) error {
+		committed, failed = true, f
+		return nil
+	})
+	out, _ := iox.NewZipOutput(filepath.Join(dir, "out.zip") + iox.NestSeparator + "a.webp")
+	out.SetZipWriter(zw, 0)
+
+	opts, _ := webp.NewEncOptionsByPreset(webp.PresetDefault, webp.LossyDefaultQuality)
+	job := &Job{
+		In:    iox.NewFileInput(filepath.Join(dir, "missing.png"), nil),
+		Out:   out,
+		Codec: &coder.WebP{Opts: opts},
+	}
+	c, _ := NewDedupCache("", iox.NewZipStore(dir, 1<<20))
+	c.run(job)
+	if job.Err == nil {
+		t.Fatal("expect error")
+	}
+	if !committed || !failed {
+		t.Fatalf("expect zip committed as failed, committed=%v failed=%v", committed, failed)
+	}
+	if _, err = zip.OpenReader(filepath.Join(dir, "out.zip")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDedupZipEntriesWithinBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	if err = os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	//distinct images, each appears twice
+	var inputs [][]byte
+	for i := 1; i <= 3; i++ {
+		var buf bytes.Buffer
+		if err = png.Encode(&buf, image.NewGray(image.Rect(0, 0, i, i))); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, buf.Bytes(), buf.Bytes())
+	}
+
+	archive := filepath.Join(dir, "out.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := iox.NewZipWriter(f, len(inputs), iox.NewZipStore(dir, 1<<20))
+	const limit = 20
+	store := iox.NewZipStore(cacheDir, limit)
+	c, _ := NewDedupCache("", store)
+	opts, _ := webp.NewEncOptionsByPreset(webp.PresetDefault, webp.LossyDefaultQuality)
+	for i, data := range inputs {
+		out, _ := iox.NewZipOutput(archive + iox.NestSeparator + fmt.Sprintf("%d.webp", i))
+		out.SetZipWriter(zw, i)
+		job := &Job{
+			In:    iox.NewBytesInput(fmt.Sprintf("%d.png", i), nil, data),
+			Out:   out,
+			Codec: &coder.WebP{Opts: opts},
+		}
+		c.run(job)
+		if job.Err != nil {
+			t.Fatal(job.Err)
+		}
+		if job.Deduped != (i%2 == 1) {
+			t.Fatalf("job %d: deduped %v", i, job.Deduped)
+		}
+		if used := store.Used(); used > limit {
+			t.Fatalf("job %d: cache holds %d bytes over budget %d", i, used, limit)
+		}
+	}
+	if spilled, _ := ioutil.ReadDir(cacheDir); len(spilled) == 0 {
+		t.Fatal("expect cached entries over budget spilled")
+	}
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != len(inputs) {
+		t.Fatalf("got %d entries", len(zr.File))
+	}
+	for i := 0; i < len(zr.File); i += 2 {
+		a, b := readZipFile(t, zr.File[i]), readZipFile(t, zr.File[i+1])
+		if len(a) == 0 || !bytes.Equal(a, b) {
+			t.Fatalf("entry %d: reused output %q differs from %q", i+1, b, a)
+		}
+	}
+
+	if err = c.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := ioutil.ReadDir(cacheDir); store.Used() != 0 || len(left) != 0 {
+		t.Fatalf("expect cache released, %d bytes and %d files held", store.Used(), len(left))
+	}
+}
+
+func readZipFile(t *testing.T, f *zip.File) []byte {
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDedupReuseSourceSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	opts, _ := webp.NewEncOptionsByPreset(webp.PresetDefault, webp.LossyDefaultQuality)
+	cachePath := filepath.Join(dir, "dedup.jsonl")
+	for i, name := range []string{"a.webp", "b.webp"} {
+		//the second job loads the persisted entry of the first
+		c, err := NewDedupCache(cachePath, iox.NewZipStore(dir, 1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wp := &coder.WebP{Opts: opts}
+		job := &Job{
+			In:    iox.NewBytesInput("in.png", nil, buf.Bytes()),
+			Out:   iox.NewFileOutput(filepath.Join(dir, name)),
+			Codec: wp,
+		}
+		c.run(job)
+		if job.Err != nil {
+			t.Fatal(job.Err)
+		}
+		if job.Deduped != (i == 1) {
+			t.Fatalf("%s: deduped %v", name, job.Deduped)
+		}
+		if wp.Width != 3 || wp.Height != 2 {
+			t.Fatalf("%s: got source size %dx%d", name, wp.Width, wp.Height)
+		}
+		if err = c.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package component
+
+import (
+	"archive/zip"
+	"github.com/mocukie/webpdeep/pkg/imagex"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Probe return file info and open content of a scanned file on demand
+type Probe struct {
+	Info func() (os.FileInfo, error)
+	Open func() (io.ReadCloser, error)
+}
+
+func fileProbe(pathname string, info os.FileInfo) *Probe {
+	return &Probe{
+		Info: func() (os.FileInfo, error) {
+			if info != nil {
+				return info, nil
+			}
+			return os.Stat(pathname)
+		},
+		Open: func() (io.ReadCloser, error) { return os.Open(pathname) },
+	}
+}
+
+func zipProbe(entry *zip.File) *Probe {
+	return &Probe{
+		Info: func() (os.FileInfo, error) { return entry.FileInfo(), nil },
+		Open: entry.Open,
+	}
+}
+
+type filterTarget struct {
+	info  os.FileInfo
+	props *imagex.Props
+}
+
+type condition struct {
+	header bool //need image header
+	test   func(t *filterTarget) bool
+}
+
+//Filter decide whether an image matched by convert pattern should be converted, all conditions must pass
+type Filter struct {
+	conds  []condition
+	header bool
+}
+
+var filterOps = []string{">=", "<=", "!=", "==", "=", ">", "<"}
+
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+var colorTypes = []string{"gray", "gray-alpha", "rgb", "rgba", "palette", "ycbcr", "cmyk"}
+
+//NewFilter parse expressions like "width>=64", "size<50M", "mtime>2020-01-01", "alpha=true", "color!=palette"
+func NewFilter(exprs []string) (*Filter, error) {
+	var f = new(Filter)
+	for _, expr := range exprs {
+		cond, err := parseCondition(expr)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid filter: "+expr)
+		}
+		f.conds = append(f.conds, cond)
+		f.header = f.header || cond.header
+	}
+	return f, nil
+}
+
+func parseCondition(expr string) (condition, error) {
+	var (
+		cond condition
+		idx  = strings.IndexAny(expr, "<>=!")
+	)
+	if idx <= 0 {
+		return cond, errors.New("expect \"key op value\"")
+	}
+	key, rest := strings.ToLower(strings.TrimSpace(expr[:idx])), expr[idx:]
+	var op string
+	for _, o := range filterOps {
+		if strings.HasPrefix(rest, o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return cond, errors.New("unknown operator")
+	}
+	value := strings.TrimSpace(rest[len(op):])
+	if op == "==" {
+		op = "="
+	}
+
+	switch key {
+	case "size":
+		n, err := parseSize(value)
+		if err != nil {
+			return cond, err
+		}
+		cond.test = func(t *filterTarget) bool { return compare(op, t.info.Size(), n) }
+	case "mtime":
+		tm, err := parseTime(value)
+		if err != nil {
+			return cond, err
+		}
+		cond.test = func(t *filterTarget) bool {
+			return compare(op, t.info.ModTime().UnixNano(), tm.UnixNano())
+		}
+	case "width", "height", "depth":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return cond, errors.WithStack(err)
+		}
+		cond.header = true
+		cond.test = func(t *filterTarget) bool {
+			var v = t.props.BitDepth
+			if key == "width" {
+				v = t.props.Width
+			} else if key == "height" {
+				v = t.props.Height
+			}
+			return compare(op, int64(v), n)
+		}
+	case "color":
+		value = strings.ToLower(value)
+		if !isEqualityOp(op) || !containsString(colorTypes, value) {
+			return cond, errors.New("color expect = or != one of: " + strings.Join(colorTypes, ", "))
+		}
+		cond.header = true
+		cond.test = func(t *filterTarget) bool { return (t.props.ColorType == value) == (op == "=") }
+	case "alpha":
+		b, err := strconv.ParseBool(value)
+		if err != nil || !isEqualityOp(op) {
+			return cond, errors.New("alpha expect = or != true/false")
+		}
+		cond.header = true
+		cond.test = func(t *filterTarget) bool { return (t.props.HasAlpha == b) == (op == "=") }
+	default:
+		return cond, errors.New("unknown key " + key + ", expect one of: size, mtime, width, height, depth, color, alpha")
+	}
+	return cond, nil
+}
+
+//Match check file by the conditions, image header is only read if file info passed
+func (f *Filter) Match(probe *Probe) (bool, error) {
+	var (
+		t   = new(filterTarget)
+		err error
+	)
+	if t.info, err = probe.Info(); err != nil {
+		return false, err
+	}
+	for _, cond := range f.conds {
+		if !cond.header && !cond.test(t) {
+			return false, nil
+		}
+	}
+	if !f.header {
+		return true, nil
+	}
+
+	r, err := probe.Open()
+	if err != nil {
+		return false, err
+	}
+	t.props, err = imagex.DecodeProps(r)
+	_ = r.Close()
+	if err != nil {
+		return false, err
+	}
+	for _, cond := range f.conds {
+		if cond.header && !cond.test(t) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compare(op string, a, b int64) bool {
+	switch op {
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	case "!=":
+		return a != b
+	}
+	return a == b
+}
+
+func isEqualityOp(op string) bool {
+	return op == "=" || op == "!="
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//parseSize parse byte size with optional K, M, G suffix in 1024 unit
+func parseSize(s string) (int64, error) {
+	var (
+		unit  = int64(1)
+		upper = strings.TrimSuffix(strings.ToUpper(s), "B")
+	)
+	if upper != "" {
+		switch upper[len(upper)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			upper = upper[:len(upper)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size: " + s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if tm, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return tm, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time: " + s + ", expect yyyy-mm-dd[Thh:mm:ss] or RFC3339")
+}
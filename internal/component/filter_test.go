@@ -0,0 +1,152 @@
+package component
+
+import (
+	"bytes"
+	"context"
+	"github.com/mocukie/webpdeep/pkg/eventbus"
+	"github.com/pkg/errors"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testFileInfo struct {
+	size  int64
+	mtime time.Time
+}
+
+func (fi testFileInfo) Name() string       { return "test.png" }
+func (fi testFileInfo) Size() int64        { return fi.size }
+func (fi testFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi testFileInfo) ModTime() time.Time { return fi.mtime }
+func (fi testFileInfo) IsDir() bool        { return false }
+func (fi testFileInfo) Sys() interface{}   { return nil }
+
+func TestFilter(t *testing.T) {
+	//10x8 rgba png with a transparent pixel
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 8))
+	img.Pix[3] = 0xff
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	info := testFileInfo{size: 3 << 20, mtime: time.Date(2020, 6, 1, 0, 0, 0, 0, time.Local)}
+	probe := func(opened *bool) *Probe {
+		return &Probe{
+			Info: func() (os.FileInfo, error) { return info, nil },
+			Open: func() (io.ReadCloser, error) {
+				*opened = true
+				return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+			},
+		}
+	}
+
+	cases := []struct {
+		exprs  []string
+		match  bool
+		opened bool //image header read
+	}{
+		{[]string{"size>=3M"}, true, false},
+		{[]string{"size < 2.5MB"}, false, false},
+		{[]string{"size=3145728"}, true, false},
+		{[]string{"mtime>2020-01-01"}, true, false},
+		{[]string{"mtime<2020-06-01T00:00:00"}, false, false},
+		{[]string{"width==10", "height!=10"}, true, true},
+		{[]string{"width>10"}, false, true},
+		{[]string{"depth=8"}, true, true},
+		{[]string{"color=rgba", "alpha=true"}, true, true},
+		{[]string{"color!=RGBA"}, false, true},
+		{[]string{"alpha!=true"}, false, true},
+		{[]string{"size<1K", "width>=1"}, false, false},
+	}
+	for _, c := range cases {
+		f, err := NewFilter(c.exprs)
+		if err != nil {
+			t.Errorf("%v: %v", c.exprs, err)
+			continue
+		}
+		var opened bool
+		match, err := f.Match(probe(&opened))
+		if err != nil || match != c.match || opened != c.opened {
+			t.Errorf("%v: got match %v opened %v %v, want %v %v", c.exprs, match, opened, err, c.match, c.opened)
+		}
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"width",
+		">=10",
+		"width=>10",
+		"width>=ten",
+		"size<-1M",
+		"size<1X",
+		"mtime>yesterday",
+		"color>rgb",
+		"color=purple",
+		"alpha>true",
+		"alpha=maybe",
+		"name=a.png",
+	} {
+		if _, err := NewFilter([]string{expr}); err == nil {
+			t.Errorf("%s: expect error", expr)
+		}
+	}
+}
+
+func TestFilterOpenError(t *testing.T) {
+	f, err := NewFilter([]string{"width>1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &Probe{
+		Info: func() (os.FileInfo, error) { return testFileInfo{}, nil },
+		Open: func() (io.ReadCloser, error) { return nil, errors.New("denied") },
+	}
+	if _, err = f.Match(probe); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+func TestFilterSingleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "a.png")
+	if err = ioutil.WriteFile(src, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, _ := NewGlobMatcher("*.zip")
+	for _, c := range []struct {
+		expr string
+		jobs int
+	}{
+		{"size<1K", 1},
+		{"size>=1K", 0},
+	} {
+		f, err := NewFilter([]string{c.expr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := &Config{
+			Src:          src,
+			Dest:         filepath.Join(dir, "out", "a.webp"),
+			ArchiveMatch: archive,
+			Filter:       f,
+			DryRun:       true,
+			JobQueue:     make(chan *Job, 1),
+		}
+		NewPathScanner(eventbus.New(), conf).Scan(context.Background())
+		if len(conf.JobQueue) != c.jobs {
+			t.Errorf("%s: got %d jobs, want %d", c.expr, len(conf.JobQueue), c.jobs)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package component
+
+import (
+	"archive/zip"
+	"bufio"
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Original decide what to do with source after converted in place
+type Original int
+
+const (
+	OriginalKeep   Original = iota //leave source alone, converted entries are added beside it in archive
+	OriginalDelete                 //remove source
+	OriginalBackup                 //move source into config.BackupDir
+)
+
+//setFileOutput set output of a loose file job, in place mode write atomically and handle the source after
+func (sc *PathScanner) setFileOutput(job *Job, pathname, outPathname string) {
+	if !sc.config.InPlace {
+		job.Out = sc.newFileOutput(outPathname)
+		return
+	}
+	job.Out = iox.NewAtomicFileOutput(outPathname)
+	job.Done = func() error {
+		if sc.config.Verify {
+			if err := verifyWebPFile(outPathname); err != nil {
+				return err
+			}
+		}
+		if pathname == outPathname {
+			return nil
+		}
+		return sc.handleOriginal(pathname)
+	}
+}
+
+func (sc *PathScanner) handleOriginal(pathname string) error {
+	var conf = sc.config
+	switch conf.Original {
+	case OriginalDelete:
+		return errors.WithStack(os.Remove(pathname))
+	case OriginalBackup:
+		rel, err := relToBase(conf.Base, pathname)
+		if err != nil {
+			return err
+		}
+		return moveFile(pathname, filepath.Join(conf.BackupDir, rel))
+	}
+	return nil
+}
+
+//inPlaceCommit return the commit function of archive rewritten into tmp, which replaces pathname on success.
+//Its error is reported against the archive rather than the entry closed last.
+func (sc *PathScanner) inPlaceCommit(pathname, tmp string) func(failed bool) error {
+	return func(failed bool) error {
+		if err := sc.commitInPlace(pathname, tmp, failed); err != nil {
+			sc.handleError(err)
+		}
+		return nil
+	}
+}
+
+func (sc *PathScanner) commitInPlace(pathname, tmp string, failed bool) error {
+	var conf = sc.config
+	if failed {
+		_ = os.Remove(tmp)
+		return errors.Errorf("archive <%s> is kept unchanged since some entries failed", pathname)
+	}
+	if conf.Verify {
+		if err := verifyWebPZip(tmp); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	if info, err := os.Stat(pathname); err == nil {
+		_ = os.Chmod(tmp, info.Mode())
+	}
+	if conf.Original == OriginalBackup {
+		if err := sc.handleOriginal(pathname); err != nil {
+			_ = os.Remove(tmp)
+			return errors.WithMessagef(err, "can not back up archive <%s>", pathname)
+		}
+	}
+	if err := os.Rename(tmp, pathname); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "can not replace archive <%s>", pathname)
+	}
+	return nil
+}
+
+func verifyWebPFile(pathname string) error {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	if _, err = webp.Decode(bufio.NewReader(f)); err != nil {
+		return errors.Wrapf(err, "verify <%s> failed", pathname)
+	}
+	return nil
+}
+
+func verifyWebPZip(pathname string) error {
+	reader, err := zip.OpenReader(pathname)
+	if err != nil {
+		return errors.Wrapf(err, "verify <%s> failed", pathname)
+	}
+	defer reader.Close()
+	for _, entry := range reader.File {
+		if !strings.EqualFold(path.Ext(entry.Name), ".webp") {
+			continue
+		}
+		r, err := entry.Open()
+		if err != nil {
+			return errors.Wrapf(err, "verify <%s%s%s> failed", pathname, iox.NestSeparator, entry.Name)
+		}
+		_, err = webp.Decode(bufio.NewReader(r))
+		_ = r.Close()
+		if err != nil {
+			return errors.Wrapf(err, "verify <%s%s%s> failed", pathname, iox.NestSeparator, entry.Name)
+		}
+	}
+	return nil
+}
+
+//moveFile rename src to dst, copy and remove if they are on different devices
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return errors.WithStack(err)
+	}
+	if err = out.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	_ = os.Chtimes(dst, time.Now(), info.ModTime())
+	return errors.WithStack(os.Remove(src))
+}
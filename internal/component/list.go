@@ -0,0 +1,140 @@
+package component
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//scanList create jobs for every path of config.Sources and config.FilesFrom
+func (sc *PathScanner) scanList() {
+	var conf = sc.config
+	for _, pathname := range conf.Sources {
+		sc.scanListEntry(pathname)
+	}
+
+	if conf.FilesFrom == "" {
+		return
+	}
+
+	var r io.Reader = os.Stdin
+	if conf.FilesFrom != "-" {
+		f, err := os.Open(conf.FilesFrom)
+		if err != nil {
+			sc.handleError(errors.Wrapf(err, "can not open file list <%s>", conf.FilesFrom))
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br, sep := listSeparator(r)
+	for {
+		line, err := br.ReadString(sep)
+		line = strings.TrimSuffix(line, string(sep))
+		if sep == '\n' {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		if line != "" {
+			sc.scanListEntry(line)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			sc.handleError(errors.Wrapf(err, "can not read file list <%s>", conf.FilesFrom))
+			break
+		}
+	}
+}
+
+//listSeparator return NUL if found in the beginning of list like find -print0, otherwise newline,
+//along with a reader of the whole list. Data is read until the first separator however long the first path is,
+//and only data arrived by then is inspected, so a pipe not closed yet does not block scanning.
+func listSeparator(r io.Reader) (*bufio.Reader, byte) {
+	var (
+		head []byte
+		buf  = make([]byte, 4096)
+		sep  = byte('\n')
+	)
+	for {
+		n, err := r.Read(buf)
+		head = append(head, buf[:n]...)
+		if bytes.IndexByte(head, 0) != -1 {
+			sep = 0
+			break
+		} else if bytes.IndexByte(head, '\n') != -1 || err != nil {
+			break
+		}
+	}
+	return bufio.NewReader(io.MultiReader(bytes.NewReader(head), r)), sep
+}
+
+func (sc *PathScanner) scanListEntry(pathname string) {
+	var conf = sc.config
+	pathname = filepath.Clean(pathname)
+	rel, err := relToBase(conf.Base, pathname)
+	if err != nil {
+		sc.handleError(errors.Wrapf(err, "can not map <%s> into output", pathname))
+		return
+	}
+
+	stat, err := os.Stat(pathname)
+	if err != nil {
+		sc.handleError(errors.Wrapf(err, "get <%s> stat failed", pathname))
+		return
+	}
+
+	outPathname := filepath.Join(conf.Dest, rel)
+	if stat.IsDir() {
+		sc.src, sc.dst = pathname, outPathname
+		sc.walk(pathname)
+		return
+	}
+
+	dir := filepath.Dir(outPathname)
+	if err = sc.mkdirAll(dir); err != nil {
+		sc.handleError(errors.Wrapf(err, "can not make output directory <%s>", dir))
+		return
+	}
+
+	if conf.ArchiveMatch(pathname, true) {
+		if conf.Container == ContainerDir {
+			sc.unpackZip(pathname, outPathname[:len(outPathname)-len(filepath.Ext(outPathname))])
+		} else {
+			sc.walkZip(pathname, outPathname)
+		}
+		return
+	}
+
+	job, outPathname := sc.matchJob(outPathname, true, fileProbe(pathname, stat))
+	if job == nil {
+		return
+	}
+	job.In = iox.NewFileInput(pathname, stat)
+	job.UpToDate = sc.upToDate(pathname, outPathname)
+	sc.setFileOutput(job, pathname, outPathname)
+	sc.sendJob(job)
+}
+
+func relToBase(base, pathname string) (string, error) {
+	var err error
+	if base, err = filepath.Abs(base); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if pathname, err = filepath.Abs(pathname); err != nil {
+		return "", errors.WithStack(err)
+	}
+	rel, err := filepath.Rel(base, pathname)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("not under base directory <%s>", base)
+	}
+	return rel, nil
+}
@@ -0,0 +1,54 @@
+package component
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListSeparator(t *testing.T) {
+	cases := []struct {
+		name string
+		list string
+		want byte
+	}{
+		{"newline", "a.png\nb.png\n", '\n'},
+		{"NUL", "a.png\x00b.png\x00", 0},
+		{"NUL after newline in name", "a\nb.png\x00", 0},
+		{"single path", "a.png", '\n'},
+		{"empty", "", '\n'},
+		{"long path", strings.Repeat("a", 5000) + "\x00b.png\x00", 0},
+	}
+	for _, c := range cases {
+		br, got := listSeparator(strings.NewReader(c.list))
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+		//data inspected is read again by the scan
+		if list, err := ioutil.ReadAll(br); err != nil || string(list) != c.list {
+			t.Errorf("%s: got list %q, %v", c.name, list, err)
+		}
+	}
+}
+
+func TestListSeparatorOpenPipe(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	go func() { _, _ = w.Write([]byte("a.png\x00")) }()
+
+	got := make(chan byte)
+	go func() {
+		_, sep := listSeparator(r)
+		got <- sep
+	}()
+	select {
+	case sep := <-got:
+		if sep != 0 {
+			t.Fatalf("got %q, want NUL", sep)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked by pipe not closed")
+	}
+}
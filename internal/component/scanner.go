@@ -3,15 +3,18 @@ package component
 import (
 	"archive/zip"
 	"context"
+	"github.com/fsnotify/fsnotify"
 	"github.com/karrick/godirwalk"
 	"github.com/mocukie/webpdeep/internal/coder"
 	"github.com/mocukie/webpdeep/internal/iox"
 	"github.com/mocukie/webpdeep/pkg/eventbus"
+	"github.com/mocukie/webpdeep/pkg/metax"
 	"github.com/mocukie/webpdeep/pkg/zipx"
 	"github.com/pkg/errors"
 	"os"
 	"path"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,21 +29,47 @@ type pathPair struct {
 	dst string
 }
 
+type zipItem struct {
+	dir *zip.FileHeader
+	job *Job
+}
+
+type zipPack struct {
+	dst   string
+	items []zipItem
+}
+
 type scannerResult struct {
 	pp       []pathPair
 	jobCount int
-	errCount int
+	errCount int32 //also counted by archive commit in workers after scanner done
 }
 
 type PathScanner struct {
-	config *Config
-	eb     *eventbus.Bus
-	result *scannerResult
+	config  *Config
+	eb      *eventbus.Bus
+	result  *scannerResult
+	store   *iox.ZipStore
+	packs   map[string]*zipPack
+	watcher *fsnotify.Watcher
+	watched map[string]bool
+	dirs    []walkingDir //directories being walked in follow mode
+	src     string       //root of current walk
+	dst     string
+	stamps  map[string]*metax.Stamp //stamp of source directories merged with sidecars
 }
 
 //config.Src and config.Dest must be cleaned by filepath.Clean first
 func NewPathScanner(eb *eventbus.Bus, config *Config) *PathScanner {
-	return &PathScanner{eb: eb, config: config, result: new(scannerResult)}
+	return &PathScanner{
+		eb:      eb,
+		config:  config,
+		result:  new(scannerResult),
+		store:   iox.NewZipStore(config.TempDir, config.ZipMemLimit),
+		packs:   map[string]*zipPack{},
+		watched: map[string]bool{},
+		stamps:  map[string]*metax.Stamp{},
+	}
 }
 
 func (sc *PathScanner) Scan(ctx context.Context) {
@@ -51,6 +80,12 @@ func (sc *PathScanner) Scan(ctx context.Context) {
 	)
 	defer sc.eb.Publish(EvtScannerDone, sc.result)
 
+	if conf.ListMode() {
+		sc.scanList()
+		return
+	}
+
+	sc.src, sc.dst = conf.Src, conf.Dest
 	stat, err = os.Stat(conf.Src)
 	if err != nil {
 		sc.handleError(errors.Wrapf(err, "get <%s> stat failed", conf.Src))
@@ -58,97 +93,211 @@ func (sc *PathScanner) Scan(ctx context.Context) {
 	}
 
 	if stat.IsDir() {
-		err = godirwalk.Walk(conf.Src, &godirwalk.Options{
-			Callback: sc.walkDir,
-			ErrorCallback: func(s string, e error) godirwalk.ErrorAction {
-				sc.handleError(errors.Wrapf(err, "walk on file node <%s> failed", s))
-				return godirwalk.SkipNode
-			},
-		})
-		if err != nil {
-			sc.handleError(errors.Wrapf(err, "can not walk directory <%s>", conf.Src))
+		if conf.Watch {
+			if sc.watcher, err = fsnotify.NewWatcher(); err != nil {
+				sc.handleError(errors.Wrap(err, "can not create file watcher"))
+				return
+			}
+			defer sc.watcher.Close()
+		}
+		sc.walk(conf.Src)
+		if conf.Watch {
+			sc.watch(ctx)
 		}
 		return
 	}
 
 	dir := filepath.Dir(conf.Dest)
-	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+	if err = sc.mkdirAll(dir); err != nil {
 		sc.handleError(errors.Wrapf(err, "can not make output directory <%s>", dir))
 		return
 	}
 
 	if conf.ArchiveMatch(conf.Src, true) {
-		sc.walkZip(conf.Src, conf.Dest)
-	} else {
+		if conf.Container == ContainerDir {
+			sc.unpackZip(conf.Src, conf.Dest)
+		} else {
+			sc.walkZip(conf.Src, conf.Dest)
+		}
+	} else if sc.filter(conf.Src, fileProbe(conf.Src, stat)) {
+		//a single image rejected by filter is skipped, its output name has no copy to fall back on
 		job := new(Job)
 		job.CopyMeta = conf.CopyFileMeta
-		job.Codec = &coder.WebP{Opts: conf.Opts, CopyMeta: conf.CopyImageMeta, CheckImage: conf.CheckImage}
+		job.Codec = &coder.WebP{Opts: conf.Opts, CopyMeta: conf.CopyImageMeta, Meta: conf.MetaPolicy, CheckImage: conf.CheckImage}
 		job.In = iox.NewFileInput(conf.Src, nil)
-		job.Out = iox.NewFileOutput(conf.Dest)
+		job.UpToDate = sc.upToDate(conf.Src, conf.Dest)
+		sc.setFileOutput(job, conf.Src, conf.Dest)
 		sc.sendJob(job)
 	}
 
 }
 
+func (sc *PathScanner) walk(pathname string) {
+	err := godirwalk.Walk(pathname, &godirwalk.Options{
+		Callback:             sc.walkDir,
+		PostChildrenCallback: sc.leaveDir,
+		FollowSymbolicLinks:  sc.config.Symlinks == SymlinkFollow,
+		ErrorCallback: func(s string, e error) godirwalk.ErrorAction {
+			sc.handleError(errors.Wrapf(e, "walk on file node <%s> failed", s))
+			return godirwalk.SkipNode
+		},
+	})
+	if err != nil {
+		sc.handleError(errors.Wrapf(err, "can not walk directory <%s>", pathname))
+	}
+}
+
+//matchJob create job for name by convert and copy pattern, return nil if not matched,
+//images rejected by config.Filter follow the copy pattern, filter is not applied if probe is nil
+func (sc *PathScanner) matchJob(name string, depPlatform bool, probe *Probe) (*Job, string) {
+	var (
+		conf = sc.config
+		job  = &Job{CopyMeta: conf.CopyFileMeta}
+	)
+	if conf.StampSidecar != "" && filepath.Base(name) == conf.StampSidecar {
+		return nil, ""
+	}
+	if conf.ConvertMatch(name, depPlatform) && sc.filter(name, probe) {
+		job.Codec = &coder.WebP{Opts: conf.Opts, CopyMeta: conf.CopyImageMeta, Meta: conf.MetaPolicy, CheckImage: conf.CheckImage}
+		ext := path.Ext(name)
+		if depPlatform {
+			ext = filepath.Ext(name)
+		}
+		return job, name[:len(name)-len(ext)] + ".webp"
+	} else if conf.CopyMatch != nil && conf.CopyMatch(name, depPlatform) {
+		job.Codec = &coder.Copy{}
+		job.CopyMeta = true
+		return job, name
+	}
+	return nil, ""
+}
+
+func (sc *PathScanner) filter(name string, probe *Probe) bool {
+	if sc.config.Filter == nil || probe == nil {
+		return true
+	}
+	ok, err := sc.config.Filter.Match(probe)
+	if err != nil {
+		sc.handleError(errors.WithMessagef(err, "can not filter <%s>", name))
+		return false
+	}
+	return ok
+}
+
 func (sc *PathScanner) walkDir(pathname string, de *godirwalk.Dirent) error {
 	var conf = sc.config
-	if conf.Src == pathname {
-		if err := os.MkdirAll(conf.Dest, os.ModePerm); err != nil {
-			sc.handleError(errors.Wrapf(err, "can not make dest directory <%s>", conf.Dest))
+	if sc.src == pathname {
+		if conf.Container == ContainerZip {
+			dst := sc.dst
+			if conf.Recursively {
+				dst = filepath.Join(sc.dst, filepath.Base(sc.src)) + conf.PackExt
+			} else if conf.ListMode() {
+				dst += conf.PackExt
+			}
+			sc.packs[pathname] = &zipPack{dst: dst}
+		} else if err := sc.mkdirAll(sc.dst); err != nil {
+			sc.handleError(errors.Wrapf(err, "can not make dest directory <%s>", sc.dst))
 			return godirwalk.SkipThis
 		}
+		sc.pushDir(pathname, false)
+		sc.addWatch(pathname)
 		return nil
-	} else if conf.Dest == pathname || conf.LogPath == pathname {
+	} else if conf.Dest == pathname || conf.LogPath == pathname || conf.BackupDir == pathname {
 		return godirwalk.SkipThis
 	}
 
-	rel, _ := filepath.Rel(conf.Src, pathname)
-	outPathname := filepath.Join(conf.Dest, rel)
-	if de.IsDir() {
+	rel, _ := filepath.Rel(sc.src, pathname)
+	outPathname := filepath.Join(sc.dst, rel)
+	isDir := de.IsDir()
+	if de.IsSymlink() {
+		switch conf.Symlinks {
+		case SymlinkSkip:
+			return godirwalk.SkipThis
+		case SymlinkPreserve:
+			sc.preserveLink(pathname, outPathname)
+			return godirwalk.SkipThis
+		}
+		info, err := os.Stat(pathname)
+		if err != nil {
+			sc.handleError(errors.Wrapf(err, "can not follow symlink <%s>", pathname))
+			return godirwalk.SkipThis
+		}
+		isDir = info.IsDir()
+		if isDir && conf.Symlinks == SymlinkFiles {
+			return godirwalk.SkipThis
+		}
+	}
+
+	if isDir {
 		var skip error
-		if conf.Recursively {
-			if err := os.MkdirAll(outPathname, os.ModePerm); err != nil {
-				sc.handleError(errors.Wrapf(err, "can not make dest directory <%s>", outPathname))
-				skip = godirwalk.SkipThis
-			} else if conf.CopyFileMeta {
-				sc.result.pp = append(sc.result.pp, pathPair{src: pathname, dst: outPathname})
-			}
-		} else {
+		if !conf.Recursively || !sc.pushDir(pathname, de.IsSymlink()) {
+			skip = godirwalk.SkipThis
+		} else if conf.Container == ContainerZip {
+			//every sub directory is packed into its own archive
+			sc.packs[pathname] = &zipPack{dst: outPathname + conf.PackExt}
+		} else if err := sc.mkdirAll(outPathname); err != nil {
+			sc.handleError(errors.Wrapf(err, "can not make dest directory <%s>", outPathname))
 			skip = godirwalk.SkipThis
+		} else if conf.CopyFileMeta {
+			sc.result.pp = append(sc.result.pp, pathPair{src: pathname, dst: outPathname})
+		}
+		if skip == nil {
+			sc.addWatch(pathname)
 		}
 		return skip
 	}
 
 	if conf.ArchiveMatch(pathname, true) {
-		if conf.Recursively {
+		if !conf.Recursively {
+			return nil
+		}
+		if conf.Container == ContainerDir {
+			sc.unpackZip(pathname, outPathname[:len(outPathname)-len(filepath.Ext(outPathname))])
+		} else {
 			sc.walkZip(pathname, outPathname)
 		}
 		return nil
 	}
 
-	var (
-		job      *Job
-		copyMeta = conf.CopyFileMeta
-	)
-	if conf.ConvertMatch(pathname, true) {
-		job = new(Job)
-		job.Codec = &coder.WebP{Opts: conf.Opts, CopyMeta: conf.CopyImageMeta, CheckImage: conf.CheckImage}
-		outPathname = outPathname[:len(outPathname)-len(filepath.Ext(outPathname))] + ".webp"
-	} else if conf.CopyMatch != nil && conf.CopyMatch(pathname, true) {
-		job = new(Job)
-		job.Codec = &coder.Copy{}
-		copyMeta = true
-	} else {
+	job, outPathname := sc.matchJob(outPathname, true, fileProbe(pathname, nil))
+	if job == nil {
 		return nil
 	}
-
-	job.CopyMeta = copyMeta
 	job.In = iox.NewFileInput(pathname, nil)
-	job.Out = iox.NewFileOutput(outPathname)
+
+	if pack, ok := sc.packs[filepath.Dir(pathname)]; ok {
+		name := filepath.Base(outPathname)
+		out, _ := iox.NewZipOutput(pack.dst + iox.NestSeparator + name)
+		out.SetCompression(conf.ZipMethodOf(name))
+		job.Out = out
+		pack.items = append(pack.items, zipItem{job: job})
+		return nil
+	}
+
+	job.UpToDate = sc.upToDate(pathname, outPathname)
+	sc.setFileOutput(job, pathname, outPathname)
 	sc.sendJob(job)
 	return nil
 }
 
+//leaveDir write out the archive of directory in pack mode
+func (sc *PathScanner) leaveDir(pathname string, _ *godirwalk.Dirent) error {
+	sc.popDir(pathname)
+	pack, ok := sc.packs[pathname]
+	if !ok {
+		return nil
+	}
+	delete(sc.packs, pathname)
+
+	if len(pack.items) == 0 {
+		return nil
+	}
+	if sc.writeZip(pack.dst, "", pack.items) && sc.config.CopyFileMeta {
+		sc.result.pp = append(sc.result.pp, pathPair{src: pathname, dst: pack.dst})
+	}
+	return nil
+}
+
 func (sc *PathScanner) walkZip(pathname, outPathname string) {
 	conf := sc.config
 	reader, err := zip.OpenReader(pathname)
@@ -157,79 +306,220 @@ func (sc *PathScanner) walkZip(pathname, outPathname string) {
 		return
 	}
 	defer reader.Close()
+	dec := zipx.NewNameDecoder(reader.File, sc.config.ZipCharset)
 
+	//keep directories and jobs in central directory order
 	var (
-		jobs []*Job
-		dirs []*zip.FileHeader
+		items    []zipItem
+		jobCount int
 	)
 	for _, entry := range reader.File {
 		if entry.Mode().IsDir() {
 			fh := entry.FileHeader
-			fh.Name, fh.NonUTF8 = zipx.DetectZipUTF8Path(&entry.FileHeader)
-			dirs = append(dirs, &fh)
+			fh.Name, fh.NonUTF8 = dec.Name(&entry.FileHeader), false
+			fh.Extra = zipx.FilterExtra(fh.Extra)
+			if !conf.CopyFileMeta {
+				fh.Modified = time.Now()
+				fh.SetMode(os.ModePerm)
+			}
+			items = append(items, zipItem{dir: &fh})
 			continue
 		}
 
-		var (
-			job        *Job
-			copyMeta   = conf.CopyFileMeta
-			outName, _ = zipx.DetectZipUTF8Path(&entry.FileHeader)
-		)
-		if conf.ConvertMatch(entry.Name, false) {
-			job = new(Job)
-			job.Codec = &coder.WebP{Opts: conf.Opts, CopyMeta: conf.CopyImageMeta, CheckImage: conf.CheckImage}
-			outName = outName[:len(outName)-len(path.Ext(outName))] + ".webp"
-		} else if conf.CopyMatch != nil && conf.CopyMatch(entry.Name, false) {
-			job = new(Job)
-			job.Codec = &coder.Copy{}
-			copyMeta = true
-		} else {
+		name := dec.Name(&entry.FileHeader)
+		job, outName := sc.matchJob(name, false, zipProbe(entry))
+		if conf.InPlace && (job == nil || (conf.Original == OriginalKeep && outName != name)) {
+			//rewritten archive keeps everything not replaced
+			keep := &Job{Codec: &coder.Copy{}, CopyMeta: true}
+			items = append(items, sc.zipEntryItem(pathname, outPathname, entry, keep, name))
+		}
+		if job == nil {
 			continue
 		}
-		job.CopyMeta = copyMeta
-		job.In, _ = iox.NewZipInput(pathname + iox.NestSeparator + entry.Name)
-		job.Out, _ = iox.NewZipOutput(outPathname + iox.NestSeparator + outName)
-		jobs = append(jobs, job)
+		items = append(items, sc.zipEntryItem(pathname, outPathname, entry, job, outName))
+		jobCount++
 	}
 
-	if len(jobs) == 0 {
+	if jobCount == 0 {
 		return
 	}
+	if sc.upToDate(pathname, outPathname) {
+		if !conf.DryRun {
+			return
+		}
+		for _, item := range items {
+			if item.job != nil {
+				item.job.UpToDate = true
+			}
+		}
+	}
+
+	if sc.writeZip(outPathname, reader.Comment, items) && conf.CopyFileMeta {
+		sc.result.pp = append(sc.result.pp, pathPair{src: pathname, dst: outPathname})
+	}
+}
+
+func (sc *PathScanner) zipEntryItem(pathname, outPathname string, entry *zip.File, job *Job, outName string) zipItem {
+	in, _ := iox.NewZipInput(pathname + iox.NestSeparator + entry.Name)
+	in.SetInfo(entry.FileInfo())
+	job.In = in
+	out, _ := iox.NewZipOutput(outPathname + iox.NestSeparator + outName)
+	out.SetEntryMeta(entry.Comment, zipx.FilterExtra(entry.Extra))
+	out.SetCompression(sc.config.ZipMethodOf(outName))
+	job.Out = out
+	return zipItem{job: job}
+}
+
+//writeZip create archive and send its jobs, the entries are written in order of items
+func (sc *PathScanner) writeZip(pathname, comment string, items []zipItem) bool {
+	dir := filepath.Dir(pathname)
+	if err := sc.mkdirAll(dir); err != nil {
+		sc.handleError(errors.Wrapf(err, "can not make output directory <%s>", dir))
+		return false
+	}
+
+	if sc.config.DryRun {
+		for _, item := range items {
+			if item.job != nil {
+				sc.sendJob(item.job)
+			}
+		}
+		return true
+	}
 
-	f, err := os.Create(outPathname)
+	var (
+		f   *os.File
+		err error
+	)
+	if sc.config.InPlace {
+		f, err = iox.CreateTemp(pathname)
+	} else {
+		f, err = os.Create(pathname)
+	}
+	if err != nil {
+		sc.handleError(errors.Wrapf(err, "can not create archive <%s>", pathname))
+		return false
+	}
+
+	zw := iox.NewZipWriter(f, len(items), sc.store)
+	if sc.config.InPlace {
+		zw.SetCommit(sc.inPlaceCommit(pathname, f.Name()))
+	}
+	if err = zw.SetComment(comment); err != nil {
+		sc.handleError(errors.Wrapf(err, "can not set archive comment <%s>", pathname))
+	}
+	for i, item := range items {
+		if item.job == nil {
+			if err = zw.Put(i, item.dir, 0, nil); err != nil {
+				sc.handleError(errors.Wrapf(err, "can not create archive entry <%s%s%s>", pathname, iox.NestSeparator, item.dir.Name))
+			}
+			continue
+		}
+		item.job.Out.(*iox.ZipOutput).SetZipWriter(zw, i)
+		sc.sendJob(item.job)
+	}
+	return true
+}
+
+//unpackZip extract entries of archive into directory outPathname
+func (sc *PathScanner) unpackZip(pathname, outPathname string) {
+	reader, err := zip.OpenReader(pathname)
 	if err != nil {
-		sc.handleError(errors.Wrapf(err, "can not create archive <%s>", outPathname))
+		sc.handleError(errors.Wrapf(err, "can not open archive <%s>", pathname))
+		return
+	}
+	defer reader.Close()
+	dec := zipx.NewNameDecoder(reader.File, sc.config.ZipCharset)
+
+	if err = sc.mkdirAll(outPathname); err != nil {
+		sc.handleError(errors.Wrapf(err, "can not make dest directory <%s>", outPathname))
 		return
 	}
 
-	zw := iox.NewZipWriter(f, int32(len(jobs)))
-	for _, dir := range dirs {
-		if !conf.CopyFileMeta {
-			dir.Modified = time.Now()
-			dir.SetMode(os.ModePerm)
+	for _, entry := range reader.File {
+		name := dec.Name(&entry.FileHeader)
+		name = path.Clean("/" + name)[1:]
+		if name == "" {
+			continue
 		}
-		_, err := zw.CreateHeader(dir)
-		if err != nil {
-			sc.handleError(errors.Wrapf(err, "can not create archive entry <%s%s%s>", outPathname, iox.NestSeparator, dir.Name))
+		outName := filepath.Join(outPathname, filepath.FromSlash(name))
+
+		if entry.Mode().IsDir() {
+			if err = sc.mkdirAll(outName); err != nil {
+				sc.handleError(errors.Wrapf(err, "can not make dest directory <%s>", outName))
+			}
+			continue
 		}
-	}
-	for _, job := range jobs {
-		job.Out.(*iox.ZipOutput).SetZipWriter(zw)
+
+		job, outName := sc.matchJob(outName, true, zipProbe(entry))
+		if job == nil {
+			continue
+		}
+		if err = sc.mkdirAll(filepath.Dir(outName)); err != nil {
+			sc.handleError(errors.Wrapf(err, "can not make dest directory <%s>", filepath.Dir(outName)))
+			continue
+		}
+		in, _ := iox.NewZipInput(pathname + iox.NestSeparator + entry.Name)
+		in.SetInfo(entry.FileInfo())
+		job.In = in
+		job.Out = sc.newFileOutput(outName)
+		job.UpToDate = sc.upToDate(pathname, outName)
 		sc.sendJob(job)
 	}
 
-	if conf.CopyFileMeta {
+	if sc.config.CopyFileMeta {
 		sc.result.pp = append(sc.result.pp, pathPair{src: pathname, dst: outPathname})
 	}
 }
 
+//newFileOutput create non-atomic output, with Update an aborted one is removed since its mtime would look up-to-date
+func (sc *PathScanner) newFileOutput(pathname string) *iox.FileOutput {
+	out := iox.NewFileOutput(pathname)
+	if sc.config.Update {
+		out.DropAborted()
+	}
+	return out
+}
+
+//mkdirAll make output directory, nothing is touched in dry run
+func (sc *PathScanner) mkdirAll(dir string) error {
+	if sc.config.DryRun {
+		return nil
+	}
+	return os.MkdirAll(dir, os.ModePerm)
+}
+
+//upToDate report whether output exists and is not older than source
+func (sc *PathScanner) upToDate(pathname, outPathname string) bool {
+	if !sc.config.Update || pathname == outPathname {
+		return false
+	}
+	out, err := os.Stat(outPathname)
+	if err != nil {
+		return false
+	}
+	in, err := os.Stat(pathname)
+	return err == nil && !out.ModTime().Before(in.ModTime())
+}
+
+//handleError report error of a path, safe to call from workers
 func (sc *PathScanner) handleError(err error) {
-	sc.result.errCount++
+	atomic.AddInt32(&sc.result.errCount, 1)
 	sc.eb.Publish(EvtScannerError, err)
 }
 
+//sendJob queue job, up-to-date job is only sent in dry run
 func (sc *PathScanner) sendJob(job *Job) {
+	if job.UpToDate && !sc.config.DryRun {
+		return
+	}
+	if wp, ok := job.Codec.(*coder.WebP); ok && (sc.config.Stamp != nil || sc.config.StampSidecar != "") {
+		wp.Stamp = sc.stampOf(job.In.Path())
+	}
 	sc.result.jobCount++
+	if !sc.config.DryRun {
+		job.queued = make(chan struct{})
+	}
 	sc.eb.Publish(EvtScannerNewJob, job)
 	sc.config.JobQueue <- job
 }
@@ -0,0 +1,146 @@
+package component
+
+import (
+	"context"
+	"github.com/mocukie/webpdeep/pkg/eventbus"
+	"sync/atomic"
+)
+
+var sessionTopics = []eventbus.Topic{
+	EvtScannerNewJob,
+	EvtScannerError,
+	EvtScannerDone,
+	EvtTransferJobDone,
+	EvtTransferDone,
+}
+
+//SessionHandler receive events of a session from a single goroutine, a job is always queued before done
+type SessionHandler struct {
+	Queued func(job *Job)  //job found by scanner
+	Done   func(job *Job)  //job finished, or planned in dry run
+	Error  func(err error) //scanner error, or error of committing an archive rewritten in place
+}
+
+//Session run scanner and transfer of config, in dry run jobs are collected instead of run
+type Session struct {
+	config   *Config
+	eb       *eventbus.Bus
+	transfer *Transfer
+	scanner  *PathScanner
+	sub      eventbus.Subscriber
+}
+
+func NewSession(config *Config) *Session {
+	if config.JobQueue == nil {
+		config.JobQueue = make(chan *Job, 1024)
+	}
+	s := &Session{config: config, eb: eventbus.New(), sub: make(eventbus.Subscriber, 512)}
+	for _, topic := range sessionTopics {
+		s.eb.Subscribe(topic, s.sub)
+	}
+	if !config.DryRun {
+		s.transfer = NewTransfer(s.eb, config)
+	}
+	s.scanner = NewPathScanner(s.eb, config)
+	return s
+}
+
+//QueueDepth return number of jobs waiting in queue
+func (s *Session) QueueDepth() int {
+	return len(s.config.JobQueue)
+}
+
+//ActiveWorkers return number of workers running a job
+func (s *Session) ActiveWorkers() int {
+	if s.transfer == nil {
+		return 0
+	}
+	return s.transfer.Active()
+}
+
+//Run block until all jobs done or ctx done, in watch mode ctx only stops watching and queued jobs are finished
+func (s *Session) Run(ctx context.Context, h SessionHandler) {
+	defer func() {
+		for _, topic := range sessionTopics {
+			s.eb.UnSubscribe(topic, s.sub)
+		}
+	}()
+
+	runCtx := ctx
+	if s.config.Watch {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+	}
+	if s.transfer != nil {
+		go s.transfer.Start(runCtx)
+	}
+	go s.scanner.Scan(ctx)
+
+	var (
+		transferDone = s.transfer == nil
+		queued       int
+		done         int
+		scanErr      int32
+		sc           *scannerResult
+		seen         = map[*Job]bool{} //queued but not done, or done before its queued event
+	)
+	queue := func(job *Job) {
+		if seen[job] {
+			delete(seen, job)
+			return
+		}
+		seen[job] = true
+		if h.Queued != nil {
+			h.Queued(job)
+		}
+		if job.queued != nil {
+			close(job.queued)
+		}
+	}
+	finish := func(job *Job) {
+		if seen[job] {
+			delete(seen, job)
+		} else {
+			seen[job] = true
+			if h.Queued != nil {
+				h.Queued(job)
+			}
+		}
+		done++
+		if h.Done != nil {
+			h.Done(job)
+		}
+	}
+
+	for !transferDone || sc == nil || queued != sc.jobCount || done != sc.jobCount || scanErr != atomic.LoadInt32(&sc.errCount) {
+		var dryJob <-chan *Job
+		if s.transfer == nil {
+			dryJob = s.config.JobQueue
+		}
+		select {
+		case job := <-dryJob:
+			finish(job)
+		case msg := <-s.sub:
+			switch msg.Topic {
+			case EvtScannerNewJob:
+				queued++
+				queue(msg.Data.(*Job))
+			case EvtTransferJobDone:
+				finish(msg.Data.(*Job))
+			case EvtScannerError:
+				scanErr++
+				if h.Error != nil {
+					err, _ := msg.Data.(error)
+					h.Error(err)
+				}
+			case EvtScannerDone:
+				sc, _ = msg.Data.(*scannerResult)
+			case EvtTransferDone:
+				transferDone = true
+			}
+		case <-runCtx.Done():
+			return
+		}
+	}
+}
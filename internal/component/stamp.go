@@ -0,0 +1,65 @@
+package component
+
+import (
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/mocukie/webpdeep/pkg/metax"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//stampOf return stamp of source pathname, config.Stamp merged with sidecars from the walk root down to its directory,
+//nearer sidecars win, entries of archive use sidecars of the archive directory
+func (sc *PathScanner) stampOf(pathname string) *metax.Stamp {
+	if idx := strings.Index(pathname, iox.NestSeparator); idx != -1 {
+		pathname = pathname[:idx]
+	}
+	s := sc.dirStamp(filepath.Dir(pathname))
+	if s.Empty() {
+		return nil
+	}
+	return s
+}
+
+func (sc *PathScanner) dirStamp(dir string) *metax.Stamp {
+	if s, ok := sc.stamps[dir]; ok {
+		return s
+	}
+
+	var (
+		conf = sc.config
+		s    = &metax.Stamp{}
+	)
+	if parent := filepath.Dir(dir); parent != dir && sc.inStampRoot(parent) {
+		s = sc.dirStamp(parent)
+	} else if conf.Stamp != nil {
+		s = conf.Stamp
+	}
+
+	if conf.StampSidecar != "" {
+		file := filepath.Join(dir, conf.StampSidecar)
+		sidecar, err := metax.LoadStamp(file)
+		if err == nil {
+			merged := s.Merge(*sidecar)
+			s = &merged
+		} else if !os.IsNotExist(errors.Cause(err)) {
+			sc.handleError(errors.WithMessagef(err, "can not load stamp sidecar <%s>", file))
+		}
+	}
+	sc.stamps[dir] = s
+	return s
+}
+
+//inStampRoot report whether dir is inside the walk root, or Base in list mode
+func (sc *PathScanner) inStampRoot(dir string) bool {
+	root := sc.config.Base
+	if !sc.config.ListMode() {
+		root = sc.config.Src
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			root = filepath.Dir(root)
+		}
+	}
+	_, err := relToBase(root, dir)
+	return err == nil
+}
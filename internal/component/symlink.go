@@ -0,0 +1,128 @@
+package component
+
+import (
+	"github.com/mocukie/webpdeep/internal/coder"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Symlinks decide how symbolic links are handled in directory walk
+type Symlinks int
+
+const (
+	SymlinkFollow   Symlinks = iota //walk linked directories and convert linked files, loops are skipped
+	SymlinkSkip                     //ignore links
+	SymlinkPreserve                 //recreate links in output, pointing at converted names
+	SymlinkFiles                    //convert linked files but do not walk linked directories, as before links were handled
+)
+
+//walkingDir is a directory in current walk path with its resolved path
+type walkingDir struct {
+	path string
+	real string
+}
+
+//pushDir enter directory in follow mode, return false if link is a loop
+func (sc *PathScanner) pushDir(pathname string, link bool) bool {
+	if sc.config.Symlinks != SymlinkFollow {
+		return true
+	}
+
+	var (
+		n    = len(sc.dirs)
+		real string
+		err  error
+	)
+	if n > 0 && !link {
+		real = filepath.Join(sc.dirs[n-1].real, filepath.Base(pathname))
+	} else if real, err = filepath.EvalSymlinks(pathname); err == nil {
+		real, err = filepath.Abs(real)
+	}
+	if err != nil {
+		sc.handleError(errors.Wrapf(err, "can not resolve directory <%s>", pathname))
+		return false
+	}
+
+	//links among siblings loop through directories other than the parent, so check the whole walk path
+	if link {
+		for _, d := range sc.dirs {
+			if d.real == real || strings.HasPrefix(d.real, real+string(filepath.Separator)) {
+				sc.handleError(errors.Errorf("symlink loop <%s> -> <%s>", pathname, real))
+				return false
+			}
+		}
+	}
+	sc.dirs = append(sc.dirs, walkingDir{path: pathname, real: real})
+	return true
+}
+
+func (sc *PathScanner) popDir(pathname string) {
+	if n := len(sc.dirs); n > 0 && sc.dirs[n-1].path == pathname {
+		sc.dirs = sc.dirs[:n-1]
+	}
+}
+
+//preserveLink recreate link in output, link to converted file is renamed as the converted file
+func (sc *PathScanner) preserveLink(pathname, outPathname string) {
+	var conf = sc.config
+	if _, ok := sc.packs[filepath.Dir(pathname)]; ok {
+		//archive can not hold links
+		return
+	}
+
+	target, err := os.Readlink(pathname)
+	if err != nil {
+		sc.handleError(errors.Wrapf(err, "can not read symlink <%s>", pathname))
+		return
+	}
+	abs := target
+	if !filepath.IsAbs(target) {
+		abs = filepath.Join(filepath.Dir(pathname), target)
+	}
+
+	//output of target, stay the same if target is outside of source
+	mapped := abs
+	if rel, e := filepath.Rel(sc.src, abs); e == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		mapped = filepath.Join(sc.dst, rel)
+		if info, err := os.Stat(abs); err != nil || !info.Mode().IsRegular() {
+			//directory or dangling link
+		} else if conf.ArchiveMatch(abs, true) {
+			if conf.Container == ContainerDir {
+				mapped = mapped[:len(mapped)-len(filepath.Ext(mapped))]
+			}
+		} else if job, name := sc.matchJob(mapped, true, fileProbe(abs, info)); job != nil {
+			if _, ok := job.Codec.(*coder.WebP); ok {
+				mapped = name
+				if conf.ConvertMatch(outPathname, true) {
+					outPathname = outPathname[:len(outPathname)-len(filepath.Ext(outPathname))] + ".webp"
+				}
+			}
+		}
+		if !filepath.IsAbs(target) {
+			target, err = filepath.Rel(filepath.Dir(outPathname), mapped)
+		} else {
+			target, err = filepath.Abs(mapped)
+		}
+	} else {
+		target, err = filepath.Abs(abs)
+	}
+	if err != nil {
+		sc.handleError(errors.Wrapf(err, "can not map symlink <%s>", pathname))
+		return
+	}
+
+	if conf.DryRun {
+		return
+	}
+	if _, err = os.Lstat(outPathname); err == nil {
+		if err = os.Remove(outPathname); err != nil {
+			sc.handleError(errors.Wrapf(err, "can not replace <%s>", outPathname))
+			return
+		}
+	}
+	if err = os.Symlink(target, outPathname); err != nil {
+		sc.handleError(errors.Wrapf(err, "can not create symlink <%s>", outPathname))
+	}
+}
@@ -0,0 +1,130 @@
+package component
+
+import (
+	"context"
+	"github.com/mocukie/webpdeep/pkg/eventbus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFollowSiblingLinkLoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	for _, d := range []string{"a", "b"} {
+		if err = os.MkdirAll(filepath.Join(src, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(src, d, d+".png"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//a/x -> b, b/y -> a
+	if err = os.Symlink(filepath.Join("..", "b"), filepath.Join(src, "a", "x")); err != nil {
+		t.Skip("symlink not supported:", err)
+	}
+	if err = os.Symlink(filepath.Join("..", "a"), filepath.Join(src, "b", "y")); err != nil {
+		t.Fatal(err)
+	}
+
+	convert, _ := NewGlobMatcher("*.png")
+	archive, _ := NewGlobMatcher("*.zip")
+	conf := &Config{
+		Src:          src,
+		Dest:         filepath.Join(dir, "out"),
+		Recursively:  true,
+		Symlinks:     SymlinkFollow,
+		ConvertMatch: convert,
+		ArchiveMatch: archive,
+		DryRun:       true,
+		JobQueue:     make(chan *Job, 64),
+	}
+	done := make(chan struct{})
+	go func() {
+		NewPathScanner(eventbus.New(), conf).Scan(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("walk does not end")
+	}
+	close(conf.JobQueue)
+
+	var got []string
+	for job := range conf.JobQueue {
+		rel, _ := filepath.Rel(src, job.In.Path())
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(got)
+	//each directory is entered once through the link from its sibling, links back to an ancestor are skipped
+	want := []string{"a/a.png", "a/x/b.png", "b/b.png", "b/y/a.png"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLinkedFilesOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err = os.MkdirAll(filepath.Join(src, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "d/b.png"} {
+		if err = ioutil.WriteFile(filepath.Join(src, filepath.FromSlash(name)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink("a.png", filepath.Join(src, "l.png")); err != nil {
+		t.Skip("symlink not supported:", err)
+	}
+	if err = os.Symlink("d", filepath.Join(src, "ld")); err != nil {
+		t.Fatal(err)
+	}
+
+	convert, _ := NewGlobMatcher("*.png")
+	archive, _ := NewGlobMatcher("*.zip")
+	conf := &Config{
+		Src:          src,
+		Dest:         filepath.Join(dir, "out"),
+		Recursively:  true,
+		Symlinks:     SymlinkFiles,
+		ConvertMatch: convert,
+		ArchiveMatch: archive,
+		DryRun:       true,
+		JobQueue:     make(chan *Job, 64),
+	}
+	NewPathScanner(eventbus.New(), conf).Scan(context.Background())
+	close(conf.JobQueue)
+
+	var got []string
+	for job := range conf.JobQueue {
+		rel, _ := filepath.Rel(src, job.In.Path())
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(got)
+	//linked file is converted, linked directory is not walked
+	want := []string{"a.png", "d/b.png", "l.png"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"github.com/mocukie/webpdeep/internal/coder"
 	"github.com/mocukie/webpdeep/internal/iox"
-	"github.com/mocukie/webpdeep/pkg/atomicx"
 	"github.com/mocukie/webpdeep/pkg/eventbus"
 	"github.com/pkg/errors"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,9 +25,39 @@ type Job struct {
 	CopyMeta bool
 	Err      error
 	Warnings []error
+	Done     func() error  //called after output closed if no error
+	UpToDate bool          //output is not older than input, only queued in dry run
+	Deduped  bool          //output reused from identical input
+	InSize   int64         //bytes read from input
+	OutSize  int64         //bytes written to output
+	Elapsed  time.Duration //time spent in codec
+	queued   chan struct{} //closed after queued event handled, the job is not run before so handlers see it unchanged
 }
 
-func (job *Job) do() {
+type countReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type countWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+//Do run job in caller goroutine
+func (job *Job) Do() {
 	var (
 		in   = job.In
 		out  = job.Out
@@ -37,9 +68,15 @@ func (job *Job) do() {
 		if e := in.Close(); e != nil && job.Err == nil {
 			job.Err = errors.WithStack(e)
 		}
+		if a, ok := out.(iox.Aborter); ok && job.Err != nil {
+			a.Abort()
+		}
 		if e := out.Close(); e != nil && job.Err == nil {
 			job.Err = errors.WithStack(e)
 		}
+		if job.Err == nil && job.Done != nil {
+			job.Err = job.Done()
+		}
 	}()
 
 	if err := in.Open(); err != nil {
@@ -60,34 +97,56 @@ func (job *Job) do() {
 		return
 	}
 
-	e, w := job.Codec.Convert(in, out)
+	var (
+		cin   = &countReader{Reader: in}
+		cout  = &countWriter{Writer: out}
+		start = time.Now()
+	)
+	e, w := job.Codec.Convert(cin, cout)
+	job.InSize, job.OutSize, job.Elapsed = cin.n, cout.n, time.Since(start)
 	if e != nil {
 		job.Err = errors.WithStack(e)
 	}
 	job.Warnings = append(job.Warnings, w...)
 }
 
+//fail end job with err without running it, input and output are closed and the output aborted like Do does,
+//so archive writers waiting for the output still see it finished
+func (job *Job) fail(err error) {
+	job.Err = err
+	_ = job.In.Close()
+	if a, ok := job.Out.(iox.Aborter); ok {
+		a.Abort()
+	}
+	_ = job.Out.Close()
+}
+
 type Transfer struct {
 	maxGo    int
-	noMore   *atomicx.Bool
+	active   int32
+	dedup    *DedupCache
+	noMore   chan struct{}
 	jobQueue <-chan *Job
 	eb       *eventbus.Bus
-	sub eventbus.Subscriber
+	sub      eventbus.Subscriber
 }
 
 func NewTransfer(eb *eventbus.Bus, config *Config) *Transfer {
-	return &Transfer{
-		noMore:   atomicx.NewBool(false),
+	tr := &Transfer{
 		maxGo:    config.MaxGo,
+		dedup:    config.Dedup,
 		jobQueue: config.JobQueue,
 		eb:       eb,
-		sub: make(eventbus.Subscriber, 1),
+		sub:      make(eventbus.Subscriber, 1),
 	}
+	//subscribe before scanner started, or scanner.done may be missed
+	tr.eb.Subscribe(EvtScannerDone, tr.sub)
+	return tr
 }
 
+//Start run jobs until scanner.done received, the scanner may live as long as ctx in watch mode
 func (tr *Transfer) Start(ctx context.Context) {
-	tr.eb.Subscribe(EvtScannerDone, tr.sub)
-	tr.noMore.Set(false)
+	tr.noMore = make(chan struct{})
 	var wg = new(sync.WaitGroup)
 	for i := 0; i < tr.maxGo; i++ {
 		wg.Add(1)
@@ -101,12 +160,15 @@ Loop:
 			wg.Wait()
 			break Loop
 		case msg := <-tr.sub:
-			tr.noMore.Set(true)
+			close(tr.noMore)
 			wg.Wait()
 			sc := msg.Data.(*scannerResult)
 			for _, pair := range sc.pp {
 				if info, err := os.Stat(pair.src); err == nil {
-					_ = os.Chmod(pair.dst, info.Mode())
+					//directory packed into zip keeps its mtime only, directory mode does not fit a file
+					if dst, err := os.Stat(pair.dst); err == nil && dst.IsDir() == info.IsDir() {
+						_ = os.Chmod(pair.dst, info.Mode())
+					}
 					_ = os.Chtimes(pair.dst, time.Now(), info.ModTime())
 				}
 			}
@@ -120,18 +182,46 @@ Loop:
 
 func (tr *Transfer) worker(wg *sync.WaitGroup, ctx context.Context) {
 	defer wg.Done()
-Loop:
 	for {
 		select {
 		case job := <-tr.jobQueue:
-			job.do()
-			tr.eb.Publish(EvtTransferJobDone, job)
+			tr.do(ctx, job)
 		case <-ctx.Done():
-			break Loop
-		default:
-			if tr.noMore.T() {
-				break Loop
+			return
+		case <-tr.noMore:
+			//all jobs are queued before scanner.done, drain them and quit
+			for {
+				select {
+				case job := <-tr.jobQueue:
+					tr.do(ctx, job)
+				case <-ctx.Done():
+					return
+				default:
+					return
+				}
 			}
 		}
 	}
 }
+
+//Active return number of workers running a job
+func (tr *Transfer) Active() int {
+	return int(atomic.LoadInt32(&tr.active))
+}
+
+func (tr *Transfer) do(ctx context.Context, job *Job) {
+	if job.queued != nil {
+		select {
+		case <-job.queued:
+		case <-ctx.Done():
+		}
+	}
+	atomic.AddInt32(&tr.active, 1)
+	defer atomic.AddInt32(&tr.active, -1)
+	if tr.dedup != nil {
+		tr.dedup.run(job)
+	} else {
+		job.Do()
+	}
+	tr.eb.Publish(EvtTransferJobDone, job)
+}
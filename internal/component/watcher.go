@@ -0,0 +1,156 @@
+package component
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/karrick/godirwalk"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type pendingFile struct {
+	last time.Time
+	size int64
+	mod  time.Time
+}
+
+func (sc *PathScanner) addWatch(pathname string) {
+	if sc.watcher == nil || sc.watched[pathname] {
+		return
+	}
+	if err := sc.watcher.Add(pathname); err != nil {
+		sc.handleError(errors.Wrapf(err, "can not watch directory <%s>", pathname))
+		return
+	}
+	sc.watched[pathname] = true
+}
+
+func (sc *PathScanner) removeWatch(pathname string) {
+	prefix := pathname + string(filepath.Separator)
+	for dir := range sc.watched {
+		if dir == pathname || strings.HasPrefix(dir, prefix) {
+			_ = sc.watcher.Remove(dir)
+			delete(sc.watched, dir)
+		}
+	}
+}
+
+//watch convert new and changed files under config.Src until ctx done,
+//files are scanned after they stay unchanged for config.WatchDelay
+func (sc *PathScanner) watch(ctx context.Context) {
+	var (
+		pending = map[string]*pendingFile{}
+		ticker  = time.NewTicker(sc.config.WatchDelay / 2)
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-sc.watcher.Events:
+			if !ok {
+				return
+			}
+			sc.handleEvent(evt, pending)
+		case err, ok := <-sc.watcher.Errors:
+			if !ok {
+				return
+			}
+			sc.handleError(errors.Wrap(err, "watch failed"))
+		case now := <-ticker.C:
+			sc.scanPending(now, pending)
+		}
+	}
+}
+
+func (sc *PathScanner) handleEvent(evt fsnotify.Event, pending map[string]*pendingFile) {
+	var (
+		conf = sc.config
+		name = filepath.Clean(evt.Name)
+	)
+	if name == conf.Dest || name == conf.LogPath {
+		return
+	}
+
+	switch {
+	case evt.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		//the new name of renamed file comes with a create event
+		delete(pending, name)
+		sc.removeWatch(name)
+		sc.removeOutput(name)
+	case evt.Op&(fsnotify.Create|fsnotify.Write) != 0:
+		p, ok := pending[name]
+		if !ok {
+			p = new(pendingFile)
+			pending[name] = p
+		}
+		//file unchanged since the event is stable at the first check
+		p.last = time.Now()
+		if info, err := os.Stat(name); err == nil {
+			p.size, p.mod = info.Size(), info.ModTime()
+		}
+	}
+}
+
+func (sc *PathScanner) scanPending(now time.Time, pending map[string]*pendingFile) {
+	for name, p := range pending {
+		if now.Sub(p.last) < sc.config.WatchDelay {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			delete(pending, name)
+			continue
+		}
+		if !info.IsDir() && (info.Size() != p.size || !info.ModTime().Equal(p.mod)) {
+			//still being written
+			p.last, p.size, p.mod = now, info.Size(), info.ModTime()
+			continue
+		}
+		delete(pending, name)
+
+		if info.IsDir() {
+			sc.walk(name)
+			continue
+		}
+		de, err := godirwalk.NewDirent(name)
+		if err != nil {
+			sc.handleError(errors.Wrapf(err, "get <%s> stat failed", name))
+			continue
+		}
+		_ = sc.walkDir(name, de)
+	}
+}
+
+//removeOutput delete output of removed or renamed source
+func (sc *PathScanner) removeOutput(pathname string) {
+	var conf = sc.config
+	rel, err := filepath.Rel(sc.src, pathname)
+	if err != nil {
+		return
+	}
+
+	var outPathname = filepath.Join(sc.dst, rel)
+	if info, e := os.Stat(outPathname); e == nil && info.IsDir() {
+		err = os.RemoveAll(outPathname)
+	} else if conf.ArchiveMatch(pathname, true) {
+		err = os.Remove(outPathname)
+	} else if job, outName := sc.matchJob(outPathname, true, nil); job != nil {
+		err = os.Remove(outName)
+		//the source may have been filtered out and copied as is
+		if conf.Filter != nil && outName != outPathname && conf.CopyMatch != nil && conf.CopyMatch(outPathname, true) {
+			if e := os.Remove(outPathname); e != nil && !os.IsNotExist(e) {
+				err = e
+			}
+		}
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		sc.handleError(errors.Wrapf(err, "can not remove output of <%s>", pathname))
+	}
+}
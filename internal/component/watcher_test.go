@@ -0,0 +1,47 @@
+package component
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/mocukie/webpdeep/pkg/eventbus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchPendingStableAtFirstCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err = os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(src, "a.png")
+	if err = ioutil.WriteFile(name, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	convert, _ := NewGlobMatcher("*.png")
+	archive, _ := NewGlobMatcher("*.zip")
+	conf := &Config{
+		Src:          src,
+		Dest:         filepath.Join(dir, "out"),
+		ConvertMatch: convert,
+		ArchiveMatch: archive,
+		WatchDelay:   time.Second,
+		DryRun:       true,
+		JobQueue:     make(chan *Job, 1),
+	}
+	sc := NewPathScanner(eventbus.New(), conf)
+	sc.src, sc.dst = conf.Src, conf.Dest
+	pending := map[string]*pendingFile{}
+	sc.handleEvent(fsnotify.Event{Name: name, Op: fsnotify.Create}, pending)
+	sc.scanPending(time.Now().Add(conf.WatchDelay), pending)
+	if len(conf.JobQueue) != 1 || len(pending) != 0 {
+		t.Fatalf("got %d jobs and %d pending files, want the file scanned", len(conf.JobQueue), len(pending))
+	}
+}
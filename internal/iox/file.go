@@ -1,8 +1,13 @@
 package iox
 
 import (
+	"bytes"
 	"github.com/pkg/errors"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,49 +42,151 @@ func (fi *FileInput) Info() (os.FileInfo, error) {
 	return fi.info, err
 }
 
-type FileOutput struct {
-	*os.File
+//BytesInput is an input already read into memory
+type BytesInput struct {
+	*bytes.Reader
 	path string
 	info os.FileInfo
 }
 
+func NewBytesInput(path string, info os.FileInfo, data []byte) *BytesInput {
+	return &BytesInput{Reader: bytes.NewReader(data), path: path, info: info}
+}
+
+func (bi *BytesInput) Path() string {
+	return bi.path
+}
+
+func (bi *BytesInput) Open() error {
+	_, err := bi.Seek(0, io.SeekStart)
+	return err
+}
+
+func (bi *BytesInput) Info() (os.FileInfo, error) {
+	return bi.info, nil
+}
+
+func (bi *BytesInput) Close() error {
+	return nil
+}
+
+type FileOutput struct {
+	*os.File
+	path        string
+	info        os.FileInfo
+	atomic      bool
+	tmp         string
+	aborted     bool
+	dropAborted bool
+}
+
 func NewFileOutput(path string) *FileOutput {
 	return &FileOutput{path: path}
 }
 
+//NewAtomicFileOutput create output written to a temp file first, which is renamed to path on success
+func NewAtomicFileOutput(path string) *FileOutput {
+	return &FileOutput{path: path, atomic: true}
+}
+
 func (fo *FileOutput) Path() string {
 	return fo.path
 }
 
 func (fo *FileOutput) Open(info os.FileInfo) error {
 	var err error
-	fo.File, err = os.Create(fo.path)
 	fo.info = info
+	fo.aborted = false
+	if !fo.atomic {
+		fo.File, err = os.Create(fo.path)
+		return err
+	}
+	fo.File, err = CreateTemp(fo.path)
+	if err == nil {
+		fo.tmp = fo.File.Name()
+	}
 	return err
 }
 
+//Abort drop the temp file of atomic output on Close, or the written file if DropAborted
+func (fo *FileOutput) Abort() {
+	fo.aborted = true
+}
+
+//DropAborted make non-atomic output removed on Close if aborted, otherwise the incomplete file is kept
+func (fo *FileOutput) DropAborted() {
+	fo.dropAborted = true
+}
+
 func (fo *FileOutput) Close() error {
-	var err error
+	var (
+		err     error
+		created = fo.File != nil
+	)
 	if fo.File != nil {
-		err = fo.File.Close()
-		if err != nil {
-			return errors.WithStack(err)
+		if fo.atomic && !fo.aborted {
+			err = fo.File.Sync()
+		}
+		if e := fo.File.Close(); err == nil {
+			err = e
 		}
 		fo.File = nil
 	}
 
-	if fo.info == nil {
-		return nil
-	}
-
-	i, p := fo.info, fo.path
-	if err = os.Chmod(p, i.Mode()); err != nil {
+	p := fo.path
+	if fo.atomic {
+		p = fo.tmp
+		if fo.tmp == "" {
+			return errors.WithStack(err)
+		}
+		fo.tmp = ""
+		if err != nil || fo.aborted {
+			_ = os.Remove(p)
+			return errors.WithStack(err)
+		}
+	} else if fo.aborted && fo.dropAborted {
+		fo.aborted = false
+		if created {
+			if e := os.Remove(p); e != nil && err == nil {
+				err = e
+			}
+		}
 		return errors.WithStack(err)
-	}
-	if err = os.Chtimes(p, time.Now(), i.ModTime()); err != nil {
+	} else if err != nil {
 		return errors.WithStack(err)
 	}
 
-	fo.info = nil
+	if i := fo.info; i != nil {
+		if err = os.Chmod(p, i.Mode()); err != nil {
+			return errors.WithStack(err)
+		}
+		if err = os.Chtimes(p, time.Now(), i.ModTime()); err != nil {
+			return errors.WithStack(err)
+		}
+		fo.info = nil
+	}
+
+	if fo.atomic {
+		if err = os.Rename(p, fo.path); err != nil {
+			_ = os.Remove(p)
+			return errors.WithStack(err)
+		}
+	}
 	return nil
 }
+
+var tempSeq uint32
+
+//CreateTemp create a hidden temp file next to path, with default permission unlike ioutil.TempFile
+func CreateTemp(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		seq := strconv.FormatUint(uint64(atomic.AddUint32(&tempSeq, 1)), 36)
+		name := filepath.Join(dir, "."+base+"."+strconv.Itoa(os.Getpid())+"-"+seq+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
@@ -0,0 +1,57 @@
+package iox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileOutputAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name   string
+		new    func(path string) *FileOutput
+		drop   bool
+		abort  bool
+		exists bool
+	}{
+		{"written", NewFileOutput, false, false, true},
+		{"aborted kept", NewFileOutput, false, true, true},
+		{"aborted dropped", NewFileOutput, true, true, false},
+		{"atomic written", NewAtomicFileOutput, false, false, true},
+		{"atomic aborted", NewAtomicFileOutput, false, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, c.name)
+			out := c.new(path)
+			if c.drop {
+				out.DropAborted()
+			}
+			if err := out.Open(nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := out.Write([]byte("data")); err != nil {
+				t.Fatal(err)
+			}
+			if c.abort {
+				out.Abort()
+			}
+			if err := out.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path); (err == nil) != c.exists {
+				t.Fatalf("exists %v, want %v", err == nil, c.exists)
+			}
+		})
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, ".*.tmp")); len(left) != 0 {
+		t.Fatalf("temp files left %v", left)
+	}
+}
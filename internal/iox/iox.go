@@ -21,3 +21,8 @@ type Output interface {
 	Open(info os.FileInfo) error
 	Close() error
 }
+
+//Aborter is implemented by outputs which can drop the written content when job failed
+type Aborter interface {
+	Abort()
+}
@@ -0,0 +1,75 @@
+package iox
+
+import (
+	"os"
+	"time"
+)
+
+//StdPath is the path of standard input and output
+const StdPath = "-"
+
+//StdInput is standard input, it is never closed
+type StdInput struct {
+	*os.File
+	info os.FileInfo
+}
+
+func NewStdInput() *StdInput {
+	return &StdInput{File: os.Stdin}
+}
+
+func (si *StdInput) Path() string {
+	return StdPath
+}
+
+func (si *StdInput) Open() error {
+	return nil
+}
+
+//Info return stat of redirected regular file, or info with current time and default mode of pipe and terminal
+func (si *StdInput) Info() (os.FileInfo, error) {
+	if si.info == nil {
+		if info, err := si.File.Stat(); err == nil && info.Mode().IsRegular() {
+			si.info = info
+		} else {
+			si.info = stdInfo{modTime: time.Now()}
+		}
+	}
+	return si.info, nil
+}
+
+func (si *StdInput) Close() error {
+	return nil
+}
+
+//StdOutput is standard output, file metadata is ignored and it is never closed
+type StdOutput struct {
+	*os.File
+}
+
+func NewStdOutput() *StdOutput {
+	return &StdOutput{File: os.Stdout}
+}
+
+func (so *StdOutput) Path() string {
+	return StdPath
+}
+
+func (so *StdOutput) Open(info os.FileInfo) error {
+	return nil
+}
+
+func (so *StdOutput) Close() error {
+	return nil
+}
+
+type stdInfo struct {
+	modTime time.Time
+}
+
+func (i stdInfo) Name() string       { return StdPath }
+func (i stdInfo) Size() int64        { return -1 }
+func (i stdInfo) Mode() os.FileMode  { return 0644 }
+func (i stdInfo) ModTime() time.Time { return i.modTime }
+func (i stdInfo) IsDir() bool        { return false }
+func (i stdInfo) Sys() interface{}   { return nil }
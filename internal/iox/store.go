@@ -0,0 +1,148 @@
+package iox
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+)
+
+//ZipStore hold finished zip entries which are waiting for their turn to be written.
+//Entries stay in memory until limit bytes used, then spill to temp files under dir.
+type ZipStore struct {
+	dir   string
+	limit int64
+	used  int64
+}
+
+func NewZipStore(dir string, limit int64) *ZipStore {
+	return &ZipStore{dir: dir, limit: limit}
+}
+
+func (s *ZipStore) acquire(n int64) bool {
+	for {
+		used := atomic.LoadInt64(&s.used)
+		if used+n > s.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+func (s *ZipStore) release(n int64) {
+	atomic.AddInt64(&s.used, -n)
+}
+
+//Used return bytes of memory budget held by buffers
+func (s *ZipStore) Used() int64 {
+	return atomic.LoadInt64(&s.used)
+}
+
+func (s *ZipStore) NewBuffer() *EntryBuffer {
+	return &EntryBuffer{store: s}
+}
+
+//EntryBuffer hold data of one entry, the temp file is only open while writing or reading,
+//so entries waiting for their turn do not hold file descriptors
+type EntryBuffer struct {
+	store *ZipStore
+	mem   bytes.Buffer
+	held  int64
+	name  string //temp file spilled into
+	file  *os.File
+}
+
+func (b *EntryBuffer) Write(p []byte) (int, error) {
+	if b.name != "" {
+		if b.file == nil {
+			return 0, errors.New("write to closed zip entry buffer")
+		}
+		return b.file.Write(p)
+	}
+
+	n := int64(len(p))
+	if b.store.acquire(n) {
+		b.held += n
+		return b.mem.Write(p)
+	}
+
+	if err := b.spill(); err != nil {
+		return 0, err
+	}
+	return b.file.Write(p)
+}
+
+func (b *EntryBuffer) spill() error {
+	var err error
+	b.file, err = ioutil.TempFile(b.store.dir, "webpdeep-*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "can not create temp file for zip entry")
+	}
+	b.name = b.file.Name()
+	if _, err = b.mem.WriteTo(b.file); err != nil {
+		return errors.Wrap(err, "can not spill zip entry to temp file")
+	}
+	b.mem = bytes.Buffer{}
+	b.store.release(b.held)
+	b.held = 0
+	return nil
+}
+
+//Close end writing, the temp file is closed until Reader
+func (b *EntryBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	b.file = nil
+	return errors.Wrap(err, "can not spill zip entry to temp file")
+}
+
+//Reader return a reader from the beginning of the buffered data
+func (b *EntryBuffer) Reader() (io.Reader, error) {
+	if b.name == "" {
+		return &b.mem, nil
+	}
+	if err := b.Close(); err != nil {
+		return nil, err
+	}
+	var err error
+	if b.file, err = os.Open(b.name); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b.file, nil
+}
+
+//Open return a reader of its own from the beginning of the buffered data,
+//readers can be used concurrently after Close
+func (b *EntryBuffer) Open() (io.ReadCloser, error) {
+	if b.name == "" {
+		return ioutil.NopCloser(bytes.NewReader(b.mem.Bytes())), nil
+	}
+	f, err := os.Open(b.name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+//Release free memory budget and remove temp file
+func (b *EntryBuffer) Release() error {
+	b.mem = bytes.Buffer{}
+	b.store.release(b.held)
+	b.held = 0
+	if b.name == "" {
+		return nil
+	}
+	if b.file != nil {
+		_ = b.file.Close()
+		b.file = nil
+	}
+	name := b.name
+	b.name = ""
+	return errors.WithStack(os.Remove(name))
+}
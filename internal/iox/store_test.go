@@ -0,0 +1,63 @@
+package iox
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEntryBufferSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewZipStore(dir, 4)
+	cases := []struct {
+		name  string
+		data  []string
+		spill bool
+	}{
+		{"memory", []string{"ab", "cd"}, false},
+		{"spilled", []string{"ab", "cd", "ef"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := store.NewBuffer()
+			for _, d := range c.data {
+				if _, err := b.Write([]byte(d)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if b.file != nil {
+				t.Fatal("expect temp file closed after writing")
+			}
+			if spilled := b.name != ""; spilled != c.spill {
+				t.Fatalf("spilled %v, want %v", spilled, c.spill)
+			}
+
+			r, err := b.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Join(c.data, ""); string(got) != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+			if err = b.Release(); err != nil {
+				t.Fatal(err)
+			}
+			if left, _ := ioutil.ReadDir(dir); len(left) != 0 || store.used != 0 {
+				t.Fatalf("expect nothing held, got %d files %d bytes", len(left), store.used)
+			}
+		})
+	}
+}
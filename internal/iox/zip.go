@@ -2,13 +2,12 @@ package iox
 
 import (
 	"archive/zip"
-	"bytes"
+	"compress/flate"
 	"github.com/pkg/errors"
 	"io"
 	"os"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -16,6 +15,7 @@ type ZipInput struct {
 	io.ReadCloser
 	zip         *zip.ReadCloser
 	entry       *zip.File
+	info        os.FileInfo
 	path        string
 	entrySepIdx int
 }
@@ -56,8 +56,16 @@ func (zi *ZipInput) Open() error {
 	return err
 }
 
+//SetInfo set entry info known by caller, so Info can be used before Open
+func (zi *ZipInput) SetInfo(info os.FileInfo) {
+	zi.info = info
+}
+
 func (zi *ZipInput) Info() (os.FileInfo, error) {
 	if zi.entry == nil {
+		if zi.info != nil {
+			return zi.info, nil
+		}
 		return nil, errors.New("zip not open yet")
 	}
 	return zi.entry.FileInfo(), nil
@@ -74,41 +82,133 @@ func (zi *ZipInput) Close() error {
 	return nil
 }
 
+type zipEntry struct {
+	fh    *zip.FileHeader
+	level int
+	data  *EntryBuffer
+	ready bool
+}
+
+//SafeZipWriter accept entries concurrently but emit them in index order
 type SafeZipWriter struct {
 	f io.Closer
 	*zip.Writer
 	*sync.Mutex
-	ref int32
+	store   *ZipStore
+	entries []zipEntry
+	next    int
+	level   int
+	failed  bool
+	commit  func(failed bool) error
+}
+
+func NewZipWriter(f io.WriteCloser, n int, store *ZipStore) *SafeZipWriter {
+	zw := &SafeZipWriter{
+		f:       f,
+		Writer:  zip.NewWriter(f),
+		Mutex:   new(sync.Mutex),
+		store:   store,
+		entries: make([]zipEntry, n),
+		level:   flate.DefaultCompression,
+	}
+	//entries are written one by one under lock, so compressor can read level of current entry
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, zw.level)
+	})
+	return zw
 }
 
-func NewZipWriter(f io.WriteCloser, ref int32) *SafeZipWriter {
-	return &SafeZipWriter{
-		f:      f,
-		Writer: zip.NewWriter(f),
-		Mutex:  new(sync.Mutex),
-		ref:    ref,
+//Put commit the entry at idx, nil fh means the entry is dropped.
+//level is used when fh.Method is zip.Deflate.
+//The zip file is closed after all entries committed.
+func (zw *SafeZipWriter) Put(idx int, fh *zip.FileHeader, level int, data *EntryBuffer) error {
+	var err error
+	if data != nil {
+		//writing is done, spilled data waits with its temp file closed
+		err = data.Close()
+	}
+	zw.Lock()
+	defer zw.Unlock()
+
+	zw.entries[idx] = zipEntry{fh: fh, level: level, data: data, ready: true}
+	for ; zw.next < len(zw.entries) && zw.entries[zw.next].ready; zw.next++ {
+		e := &zw.entries[zw.next]
+		if e2 := zw.writeEntry(e); e2 != nil && err == nil {
+			err = e2
+		}
+		if e.data != nil {
+			if e2 := e.data.Release(); e2 != nil && err == nil {
+				err = e2
+			}
+		}
+		zw.entries[zw.next] = zipEntry{ready: true}
+	}
+
+	if err != nil {
+		zw.failed = true
+	}
+	if zw.next == len(zw.entries) {
+		if e := zw.Writer.Close(); e != nil && err == nil {
+			err = errors.WithStack(e)
+		}
+		if e := zw.f.Close(); e != nil && err == nil {
+			err = errors.WithStack(e)
+		}
+		if zw.commit != nil {
+			if e := zw.commit(zw.failed || err != nil); e != nil && err == nil {
+				err = e
+			}
+		}
 	}
+	return err
+}
+
+//Fail mark the archive incomplete, commit function will be told
+func (zw *SafeZipWriter) Fail() {
+	zw.Lock()
+	zw.failed = true
+	zw.Unlock()
+}
+
+//SetCommit set fn called after the zip file closed
+func (zw *SafeZipWriter) SetCommit(fn func(failed bool) error) {
+	zw.commit = fn
 }
 
-func (zw *SafeZipWriter) UnRef() error {
-	if atomic.AddInt32(&zw.ref, -1) != 0 {
+func (zw *SafeZipWriter) writeEntry(e *zipEntry) error {
+	if e.fh == nil {
 		return nil
 	}
-	if err := zw.Writer.Close(); err != nil {
-		return errors.WithStack(err)
+	zw.level = e.level
+	w, err := zw.CreateHeader(e.fh)
+	if err != nil {
+		return errors.Wrapf(err, "can not create zip entry <%s>", e.fh.Name)
 	}
-	if err := zw.f.Close(); err != nil {
-		return errors.WithStack(err)
+	if e.data != nil {
+		var r io.Reader
+		if r, err = e.data.Reader(); err != nil {
+			return err
+		}
+		if _, err = io.Copy(w, r); err != nil {
+			return errors.Wrapf(err, "can not write zip entry <%s>", e.fh.Name)
+		}
 	}
 	return nil
 }
 
 type ZipOutput struct {
 	io.Writer
+	buf         *EntryBuffer
 	zip         *SafeZipWriter
+	idx         int
 	path        string
 	entrySepIdx int
 	fh          *zip.FileHeader
+	comment     string
+	extra       []byte
+	method      uint16
+	level       int
+	aborted     bool
 }
 
 func NewZipOutput(path string) (*ZipOutput, error) {
@@ -119,11 +219,27 @@ func NewZipOutput(path string) (*ZipOutput, error) {
 	return &ZipOutput{
 		path:        path,
 		entrySepIdx: idx,
+		method:      zip.Deflate,
+		level:       flate.DefaultCompression,
 	}, nil
 }
 
-func (zo *ZipOutput) SetZipWriter(zw *SafeZipWriter) {
+//SetZipWriter bind output to the idx-th entry of zw
+func (zo *ZipOutput) SetZipWriter(zw *SafeZipWriter, idx int) {
 	zo.zip = zw
+	zo.idx = idx
+}
+
+//SetEntryMeta set comment and extra field copied from source entry
+func (zo *ZipOutput) SetEntryMeta(comment string, extra []byte) {
+	zo.comment = comment
+	zo.extra = extra
+}
+
+//SetCompression set compression method and deflate level of entry
+func (zo *ZipOutput) SetCompression(method uint16, level int) {
+	zo.method = method
+	zo.level = level
 }
 
 func (zo *ZipOutput) Path() string {
@@ -131,7 +247,8 @@ func (zo *ZipOutput) Path() string {
 }
 
 func (zo *ZipOutput) Open(info os.FileInfo) error {
-	zo.Writer = bytes.NewBuffer(nil)
+	zo.buf = zo.zip.store.NewBuffer()
+	zo.Writer = zo.buf
 
 	var fh *zip.FileHeader
 
@@ -142,29 +259,26 @@ func (zo *ZipOutput) Open(info os.FileInfo) error {
 		fh.Modified = time.Now()
 	}
 	fh.Name = zo.path[zo.entrySepIdx+1:]
+	fh.Comment = zo.comment
+	fh.Extra = zo.extra
+	fh.Method = zo.method
 	zo.fh = fh
 	return nil
 }
 
-func (zo *ZipOutput) Close() error {
-	var err error
-	var z = zo.zip
-	defer z.Unlock()
-	z.Lock()
-	if zo.fh != nil {
-		var w io.Writer
-		w, err = z.CreateHeader(zo.fh)
-		if err == nil {
-			_, err = io.Copy(w, zo.Writer.(*bytes.Buffer))
-		}
-	}
-
-	if err := zo.zip.UnRef(); err != nil {
-		return errors.WithStack(err)
-	}
+//Abort mark the archive incomplete, the entry is still written to keep others in order
+func (zo *ZipOutput) Abort() {
+	zo.aborted = true
+}
 
-	if err != nil {
-		return errors.WithStack(err)
+func (zo *ZipOutput) Close() error {
+	if zo.aborted {
+		zo.zip.Fail()
+		zo.aborted = false
 	}
-	return nil
+	err := zo.zip.Put(zo.idx, zo.fh, zo.level, zo.buf)
+	zo.fh = nil
+	zo.buf = nil
+	zo.Writer = nil
+	return err
 }
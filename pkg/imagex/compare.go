@@ -3,6 +3,7 @@ package imagex
 import (
 	"image"
 	"image/color"
+	"math"
 )
 
 func IsImageEqual(img1, img2 image.Image) bool {
@@ -35,3 +36,34 @@ func IsImageEqual(img1, img2 image.Image) bool {
 	}
 	return true
 }
+
+//PSNR return peak signal-to-noise ratio in dB of premultiplied 8 bits RGBA channels,
+//+Inf for identical images and 0 for images of different bounds
+func PSNR(img1, img2 image.Image) float64 {
+	if img1.Bounds() != img2.Bounds() {
+		return 0
+	}
+	rect := img1.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	if w == 0 || h == 0 {
+		return math.Inf(1)
+	}
+	var sum float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r1, g1, b1, a1 := img1.At(rect.Min.X+x, rect.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := img2.At(rect.Min.X+x, rect.Min.Y+y).RGBA()
+			for _, d := range [...]float64{
+				float64(r1>>8) - float64(r2>>8), float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8), float64(a1>>8) - float64(a2>>8),
+			} {
+				sum += d * d
+			}
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	mse := sum / float64(w*h*4)
+	return 10 * math.Log10(255*255/mse)
+}
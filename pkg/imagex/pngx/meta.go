@@ -6,17 +6,25 @@ import (
 	"compress/zlib"
 	"encoding/binary"
 	"github.com/mocukie/webpdeep/pkg/imagex"
+	"github.com/mocukie/webpdeep/pkg/metax"
 	"hash"
 	"hash/crc32"
 	"image/png"
 	"io"
 	"io/ioutil"
 	"math"
+	"strings"
+	"time"
 	"unsafe"
 )
 
 const pngMagic = "\x89PNG\r\n\x1a\n"
 
+//maxInflateSize limit decompressed size of a chunk, compressed text is a memory amplifier
+const maxInflateSize = 16 << 20
+
+const xmpKeyword = "XML:com.adobe.xmp"
+
 type iTXtChunk struct {
 	key               string
 	languageTag       string
@@ -30,6 +38,36 @@ type MetaReader struct {
 	tmp     *bytes.Buffer
 	crc     hash.Hash32
 	tags    map[string][]byte
+	texts   map[string][]metax.LangText //text chunks of textKeywords, merged into XMP by Get
+}
+
+//textKeywords map keywords of text chunks to XMP properties in the order written
+var textKeywords = []struct {
+	keyword string
+	prop    string
+	build   func(name string, texts []metax.LangText) (metax.XMPProp, bool)
+}{
+	{"Title", "dc:title", xmpAlt},
+	{"Author", "dc:creator", xmpSeq},
+	{"Description", "dc:description", xmpAlt},
+	{"Copyright", "dc:rights", xmpAlt},
+	{"Creation Time", "xmp:CreateDate", xmpDate},
+	{"Software", "xmp:CreatorTool", xmpText},
+}
+
+//textDateLayouts are layouts of Creation Time, RFC 1123 is recommended by PNG spec
+var textDateLayouts = []struct {
+	layout string
+	zone   bool
+}{
+	{time.RFC1123Z, true},
+	{time.RFC1123, true},
+	{time.RFC3339, true},
+	{"2 Jan 2006 15:04:05 -0700", true},
+	{"2006-01-02T15:04:05", false},
+	{"2006-01-02 15:04:05", false},
+	{"2006:01:02 15:04:05", false},
+	{"2006-01-02", false},
 }
 
 func NewMetaReader(reader io.Reader) (imagex.MetaChunksReader, error) {
@@ -48,16 +86,67 @@ func NewMetaReader(reader io.Reader) (imagex.MetaChunksReader, error) {
 		crc:     crc32.NewIEEE(),
 		reaming: len(pngMagic),
 		tags:    map[string][]byte{},
+		texts:   map[string][]metax.LangText{},
 	}, nil
 
 }
 
 func (mr *MetaReader) Get(tag string) []byte {
+	if tag == "XMP" && len(mr.texts) != 0 {
+		mr.mergeTexts()
+	}
 	return mr.tags[tag]
 }
 
 func (mr *MetaReader) Empty() bool {
-	return len(mr.tags) == 0
+	return len(mr.tags) == 0 && len(mr.texts) == 0
+}
+
+//mergeTexts write text chunks into XMP, properties of existing XMP are kept
+func (mr *MetaReader) mergeTexts() {
+	var props []metax.XMPProp
+	for _, k := range textKeywords {
+		if texts := mr.texts[k.keyword]; len(texts) != 0 {
+			if prop, ok := k.build(k.prop, texts); ok {
+				props = append(props, prop)
+			}
+		}
+	}
+	mr.texts = nil
+	if len(props) == 0 {
+		return
+	}
+	//invalid XMP is copied as is
+	if xmp, err := metax.MergeXMP(mr.tags["XMP"], props...); err == nil {
+		mr.tags["XMP"] = xmp
+	}
+}
+
+//wantText report whether text of keyword is kept, so other text is not decompressed
+func (mr *MetaReader) wantText(key string) bool {
+	if mr.texts == nil {
+		//already merged into XMP
+		return false
+	}
+	for _, k := range textKeywords {
+		if k.keyword == key {
+			return true
+		}
+	}
+	return false
+}
+
+//addText keep text of well-known keyword, the first one of each language wins
+func (mr *MetaReader) addText(key, lang, text string) {
+	if !mr.wantText(key) || text == "" {
+		return
+	}
+	for _, t := range mr.texts[key] {
+		if t.Lang == lang {
+			return
+		}
+	}
+	mr.texts[key] = append(mr.texts[key], metax.LangText{Lang: lang, Text: text})
 }
 
 func (mr *MetaReader) Read(p []byte) (int, error) {
@@ -112,11 +201,12 @@ func (mr *MetaReader) parseChunk(read []byte) (err error) {
 
 		var tagName string
 		var bodyParse func(io.Reader) ([]byte, error)
+		var optional bool //parse error is ignored and the chunk skipped, text chunks are not required by decoding
 
 		if string(fourcc) == "iCCP" && mr.tags["ICCP"] == nil {
 			tagName = "ICCP"
 			bodyParse = func(body io.Reader) ([]byte, error) {
-				_, icc, err := mr.parseZTXt(body.(*bufio.Reader))
+				_, icc, err := mr.parseZTXt(body.(*bufio.Reader), nil)
 				return icc, err
 			}
 		} else if string(fourcc) == "eXIf" && mr.tags["EXIF"] == nil {
@@ -124,13 +214,38 @@ func (mr *MetaReader) parseChunk(read []byte) (err error) {
 			bodyParse = ioutil.ReadAll
 		} else if string(fourcc) == "iTXt" {
 			tagName = "XMP"
+			optional = true
 			bodyParse = func(body io.Reader) ([]byte, error) {
-				iTXt, err := mr.parseITXt(body.(*bufio.Reader))
-				if err != nil || iTXt.key != "XML:com.adobe.xmp" {
+				iTXt, err := mr.parseITXt(body.(*bufio.Reader), func(key string) bool {
+					return key == xmpKeyword || mr.wantText(key)
+				})
+				if err != nil || iTXt.text == nil {
 					return nil, err
+				} else if iTXt.key != xmpKeyword {
+					mr.addText(iTXt.key, iTXt.languageTag, string(iTXt.text))
+					return nil, nil
 				}
 				return iTXt.text, nil
 			}
+		} else if string(fourcc) == "tEXt" || string(fourcc) == "zTXt" {
+			compressed := string(fourcc) == "zTXt"
+			optional = true
+			bodyParse = func(body io.Reader) ([]byte, error) {
+				var (
+					key  string
+					text []byte
+					err  error
+				)
+				if compressed {
+					key, text, err = mr.parseZTXt(body.(*bufio.Reader), mr.wantText)
+				} else {
+					key, text, err = mr.parseTEXt(body.(*bufio.Reader), mr.wantText)
+				}
+				if err == nil && text != nil {
+					mr.addText(key, "", latin1(text))
+				}
+				return nil, err
+			}
 		} else {
 			pos += delta
 			continue
@@ -144,9 +259,16 @@ func (mr *MetaReader) parseChunk(read []byte) (err error) {
 
 		var tagData []byte
 		tagData, err = bodyParse(body)
+		if err != nil && optional {
+			tagData, err = nil, nil
+		}
 		if err != nil {
 			break
 		}
+		//rest of skipped or partly parsed body, checksum follows it
+		if _, err = io.Copy(ioutil.Discard, body); err != nil {
+			break
+		}
 
 		var ok bool
 		if ok, err = mr.verifyChecksum(chunk); err != nil {
@@ -191,11 +313,25 @@ func (mr *MetaReader) verifyChecksum(r io.Reader) (bool, error) {
 	return checksum == mr.crc.Sum32(), nil
 }
 
-func (mr *MetaReader) parseZTXt(body *bufio.Reader) (string, []byte, error) {
+//parseTEXt read tEXt chunk, text is nil if want reports false for the keyword
+func (mr *MetaReader) parseTEXt(body *bufio.Reader, want func(key string) bool) (string, []byte, error) {
+	key, err := readCStr(body, 79)
+	if err != nil || !want(key) {
+		return key, nil, err
+	}
+	text, err := ioutil.ReadAll(body)
+	return key, text, err
+}
+
+//parseZTXt read zTXt or iCCP chunk, text is not decompressed if want reports false for the keyword, nil want for all
+func (mr *MetaReader) parseZTXt(body *bufio.Reader, want func(key string) bool) (string, []byte, error) {
 	key, err := readCStr(body, 79)
 	if err != nil {
 		return "", nil, err
 	}
+	if want != nil && !want(key) {
+		return key, nil, nil
+	}
 
 	if b, err := body.ReadByte(); err != nil {
 		return "", nil, err
@@ -210,33 +346,35 @@ func (mr *MetaReader) parseZTXt(body *bufio.Reader) (string, []byte, error) {
 	}
 	defer zr.Close()
 
-	value := bytes.NewBuffer(make([]byte, 0, bytes.MinRead))
-	if _, err := value.ReadFrom(zr); err != nil {
+	value, err := readInflated(zr)
+	if err != nil {
 		return "", nil, err
 	}
-
-	return key, value.Bytes(), nil
+	return key, value, nil
 }
 
-func (mr *MetaReader) parseITXt(body *bufio.Reader) (*iTXtChunk, error) {
+//parseITXt read iTXt chunk, text is nil if want reports false for the keyword
+func (mr *MetaReader) parseITXt(body *bufio.Reader, want func(key string) bool) (*iTXtChunk, error) {
 	var err error
 	var iTXt = new(iTXtChunk)
 	iTXt.key, err = readCStr(body, 79)
 	if err != nil {
 		return nil, err
 	}
+	if !want(iTXt.key) {
+		return iTXt, nil
+	}
 
 	com, err := body.ReadByte()
 	if err != nil {
 		return nil, err
 	}
-	if com == 1 {
-		if b, err := body.ReadByte(); err != nil {
-			return nil, err
-		} else if b != 0 {
-			//The only presently legitimate value for Compression method is 0 (deflate/inflate compression)
-			return nil, png.FormatError("unknown compression method")
-		}
+	//compression method is present even if the text is not compressed
+	if b, err := body.ReadByte(); err != nil {
+		return nil, err
+	} else if com == 1 && b != 0 {
+		//The only presently legitimate value for Compression method is 0 (deflate/inflate compression)
+		return nil, png.FormatError("unknown compression method")
 	}
 
 	iTXt.languageTag, err = readCStr(body, -1)
@@ -258,19 +396,28 @@ func (mr *MetaReader) parseITXt(body *bufio.Reader) (*iTXtChunk, error) {
 		defer r.(io.ReadCloser).Close()
 	}
 
-	value := bytes.NewBuffer(make([]byte, 0, bytes.MinRead))
-	if _, err = value.ReadFrom(r); err != nil {
+	if iTXt.text, err = readInflated(r); err != nil {
 		return nil, err
 	}
-	iTXt.text = value.Bytes()
-
 	return iTXt, nil
 }
 
+//readInflated read r up to maxInflateSize
+func readInflated(r io.Reader) ([]byte, error) {
+	value := bytes.NewBuffer(make([]byte, 0, bytes.MinRead))
+	if _, err := value.ReadFrom(io.LimitReader(r, maxInflateSize+1)); err != nil {
+		return nil, err
+	}
+	if value.Len() > maxInflateSize {
+		return nil, png.FormatError("chunk too large after decompressed")
+	}
+	return value.Bytes(), nil
+}
+
 func readCStr(r *bufio.Reader, lim int) (string, error) {
 	var str = make([]byte, 0, 32)
 	if lim <= 0 {
-		lim = math.MinInt32
+		lim = math.MaxInt32
 	}
 	for n := 0; n < lim; n++ {
 		if b, err := r.ReadByte(); err != nil {
@@ -284,6 +431,41 @@ func readCStr(r *bufio.Reader, lim int) (string, error) {
 	return *(*string)(unsafe.Pointer(&str)), nil
 }
 
+//latin1 decode ISO 8859-1 text of tEXt and zTXt
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+func xmpText(name string, texts []metax.LangText) (metax.XMPProp, bool) {
+	return metax.XMPText(name, texts[0].Text), true
+}
+
+func xmpAlt(name string, texts []metax.LangText) (metax.XMPProp, bool) {
+	return metax.XMPAlt(name, texts...), true
+}
+
+//xmpSeq write the first text as the only item, other languages are translations of it
+func xmpSeq(name string, texts []metax.LangText) (metax.XMPProp, bool) {
+	return metax.XMPSeq(name, texts[0].Text), true
+}
+
+//xmpDate convert date into ISO 8601 required by XMP, unknown format is dropped
+func xmpDate(name string, texts []metax.LangText) (metax.XMPProp, bool) {
+	for _, l := range textDateLayouts {
+		if t, err := time.Parse(l.layout, strings.TrimSpace(texts[0].Text)); err == nil {
+			if l.zone {
+				return metax.XMPText(name, t.Format(time.RFC3339)), true
+			}
+			return metax.XMPText(name, t.Format("2006-01-02T15:04:05")), true
+		}
+	}
+	return metax.XMPProp{}, false
+}
+
 func init() {
 	imagex.RegisterFormat("png", pngMagic, NewMetaReader, png.Decode)
 }
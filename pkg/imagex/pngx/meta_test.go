@@ -0,0 +1,110 @@
+package pngx
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"github.com/mocukie/webpdeep/pkg/imagex"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type testChunk struct {
+	typ  string
+	data string
+}
+
+//buildPNG insert chunks after IHDR of a 1x1 PNG
+func buildPNG(t *testing.T, chunks ...testChunk) []byte {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	//magic and IHDR
+	head := len(pngMagic) + 8 + 13 + 4
+	out := append([]byte{}, img.Bytes()[:head]...)
+	for _, c := range chunks {
+		b := make([]byte, 8, 12+len(c.data))
+		binary.BigEndian.PutUint32(b, uint32(len(c.data)))
+		copy(b[4:], c.typ)
+		b = append(b, c.data...)
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], crc32.ChecksumIEEE(b[4:len(b)-4]))
+		out = append(out, b...)
+	}
+	return append(out, img.Bytes()[head:]...)
+}
+
+func deflate(s string) string {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, _ = zw.Write([]byte(s))
+	_ = zw.Close()
+	return buf.String()
+}
+
+func TestMetaReaderText(t *testing.T) {
+	cases := []struct {
+		name   string
+		chunks []testChunk
+		want   []string //substrings of XMP, nil if no XMP
+	}{
+		{"tEXt latin1", []testChunk{{"tEXt", "Author\x00Ren\xe9"}},
+			[]string{"<dc:creator><rdf:Seq><rdf:li>René</rdf:li></rdf:Seq></dc:creator>"}},
+		{"zTXt", []testChunk{{"zTXt", "Description\x00\x00" + deflate("a description")}},
+			[]string{`<rdf:li xml:lang="x-default">a description</rdf:li>`}},
+		{"iTXt with language", []testChunk{
+			{"iTXt", "Title\x00\x00\x00de\x00Titel\x00Ein Titel"},
+			{"iTXt", "Title\x00\x01\x00en\x00\x00" + deflate("A title")},
+		}, []string{`<rdf:li xml:lang="de">Ein Titel</rdf:li>`, `<rdf:li xml:lang="en">A title</rdf:li>`}},
+		{"creation time", []testChunk{{"tEXt", "Creation Time\x00Mon, 02 Jan 2006 15:04:05 +0700"}},
+			[]string{"2006-01-02T15:04:05+07:00"}},
+		{"existing XMP kept", []testChunk{
+			{"iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00" +
+				`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+				`<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="kept"/></rdf:RDF></x:xmpmeta>`},
+			{"tEXt", "Software\x00dropped"},
+			{"tEXt", "Author\x00added"},
+		}, []string{`xmp:CreatorTool="kept"`, "<rdf:li>added</rdf:li>"}},
+		{"unknown keyword", []testChunk{{"tEXt", "Comment\x00ignored"}}, nil},
+		{"malformed zTXt", []testChunk{
+			{"zTXt", "Author\x00\x00not zlib"},
+			{"tEXt", "Title\x00after"},
+		}, []string{"after"}},
+		{"malformed iTXt", []testChunk{{"iTXt", "Title\x00\x01\x00\x00\x00broken"}}, nil},
+		{"keyword too long", []testChunk{{"tEXt", strings.Repeat("k", 100)}}, nil},
+		{"unknown zTXt not inflated", []testChunk{{"zTXt", "Comment\x00\x00not zlib"}}, nil},
+		{"inflated too large", []testChunk{{"zTXt", "Author\x00\x00" + deflate(strings.Repeat("a", maxInflateSize+1))}}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := buildPNG(t, c.chunks...)
+			//errors of reader are not all seen by png.Decode, read through it alone first
+			r, err := NewMetaReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(out, data) {
+				t.Fatalf("read through: %v", err)
+			}
+
+			_, _, meta, err := imagex.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			xmp := string(meta.Get("XMP"))
+			if c.want == nil && xmp != "" {
+				t.Fatalf("expect no XMP, got %s", xmp)
+			}
+			for _, w := range c.want {
+				if !strings.Contains(xmp, w) {
+					t.Errorf("expect %q in %s", w, xmp)
+				}
+			}
+		})
+	}
+}
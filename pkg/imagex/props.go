@@ -0,0 +1,142 @@
+package imagex
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	pngMagic = "\x89PNG\r\n\x1a\n"
+	bmpMagic = "BM"
+)
+
+//Props is the image properties read from header without decoding pixels
+type Props struct {
+	Format    string
+	Width     int
+	Height    int
+	BitDepth  int    //bits per channel
+	ColorType string //one of gray, gray-alpha, rgb, rgba, palette, ycbcr, cmyk, unknown
+	HasAlpha  bool
+}
+
+//DecodeProps read image properties, png and bmp headers are parsed directly for exact bit depth and alpha
+func DecodeProps(r io.Reader) (*Props, error) {
+	rr := asReader(r)
+	if header, err := rr.Peek(len(pngMagic)); err == nil && string(header) == pngMagic {
+		return decodePNGProps(rr)
+	}
+
+	//bmp decoder reports rgba model for both 24 and 32 bits, keep bit count before the header is consumed
+	bpp := bmpBitCount(rr)
+	conf, name, err := image.DecodeConfig(rr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	p := &Props{Format: name, Width: conf.Width, Height: conf.Height, BitDepth: 8}
+	switch m := conf.ColorModel.(type) {
+	case color.Palette:
+		p.ColorType = "palette"
+		for _, c := range m {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				p.HasAlpha = true
+				break
+			}
+		}
+	default:
+		switch m {
+		case color.GrayModel:
+			p.ColorType = "gray"
+		case color.Gray16Model:
+			p.ColorType, p.BitDepth = "gray", 16
+		case color.RGBAModel:
+			p.ColorType = "rgb"
+		case color.RGBA64Model:
+			p.ColorType, p.BitDepth = "rgb", 16
+		case color.NRGBAModel:
+			p.ColorType, p.HasAlpha = "rgba", true
+		case color.NRGBA64Model:
+			p.ColorType, p.BitDepth, p.HasAlpha = "rgba", 16, true
+		case color.YCbCrModel:
+			p.ColorType = "ycbcr"
+		case color.CMYKModel:
+			p.ColorType = "cmyk"
+		default:
+			p.ColorType = "unknown"
+		}
+	}
+
+	if name == "bmp" && p.ColorType == "rgb" && bpp == 32 {
+		p.ColorType, p.HasAlpha = "rgba", true
+	}
+	return p, nil
+}
+
+//bmpBitCount peek bits per pixel of bmp header, 0 if not a bmp
+func bmpBitCount(rr reader) int {
+	header, err := rr.Peek(30)
+	if err != nil || string(header[:2]) != bmpMagic {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint16(header[28:]))
+}
+
+//decodePNGProps read IHDR and look for tRNS before image data
+func decodePNGProps(r io.Reader) (*Props, error) {
+	if _, err := io.CopyN(ioutil.Discard, r, int64(len(pngMagic))); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var (
+		p   = &Props{Format: "png"}
+		buf [13]byte
+	)
+	for first := true; ; first = false {
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		length, fourcc := binary.BigEndian.Uint32(buf[:4]), string(buf[4:8])
+		if first {
+			if fourcc != "IHDR" || length != 13 {
+				return nil, errors.New("png: invalid IHDR chunk")
+			}
+			if _, err := io.ReadFull(r, buf[:13]); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			p.Width = int(binary.BigEndian.Uint32(buf[0:4]))
+			p.Height = int(binary.BigEndian.Uint32(buf[4:8]))
+			p.BitDepth = int(buf[8])
+			switch buf[9] {
+			case 0:
+				p.ColorType = "gray"
+			case 2:
+				p.ColorType = "rgb"
+			case 3:
+				p.ColorType = "palette"
+			case 4:
+				p.ColorType, p.HasAlpha = "gray-alpha", true
+			case 6:
+				p.ColorType, p.HasAlpha = "rgba", true
+			default:
+				return nil, errors.Errorf("png: invalid color type %d", buf[9])
+			}
+			length = 0
+		}
+
+		switch fourcc {
+		case "tRNS":
+			p.HasAlpha = true
+			return p, nil
+		case "IDAT", "IEND":
+			return p, nil
+		}
+		//skip chunk data and crc
+		if _, err := io.CopyN(ioutil.Discard, r, int64(length)+4); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package imagex
+
+import (
+	"bytes"
+	"golang.org/x/image/bmp"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestDecodePropsBMPAlpha(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		alpha uint8
+		color string
+	}{
+		{"opaque", 0xff, "rgb"},
+		{"translucent", 0x80, "rgba"},
+	} {
+		img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = c.alpha
+		}
+		var buf bytes.Buffer
+		if err := bmp.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		//hide Seek like a zip entry reader
+		p, err := DecodeProps(struct{ io.Reader }{bytes.NewReader(buf.Bytes())})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if p.Format != "bmp" || p.ColorType != c.color || p.HasAlpha != (c.color == "rgba") {
+			t.Errorf("%s: got %+v", c.name, p)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package logx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const timeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+//TextEncoder write `time LEVEL message key=value ...`, values with spaces or quotes are quoted
+type TextEncoder struct{}
+
+func (TextEncoder) Encode(buf *bytes.Buffer, e *Entry) {
+	buf.WriteString(e.Time.Format(timeLayout))
+	fmt.Fprintf(buf, " %-5s ", e.Level)
+	buf.WriteString(e.Message)
+	for _, f := range e.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		s := formatValue(f.Value)
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+//JSONEncoder write an object per line with time, level, msg and fields
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(buf *bytes.Buffer, e *Entry) {
+	buf.WriteString(`{"time":`)
+	writeJSON(buf, e.Time.Format(timeLayout))
+	buf.WriteString(`,"level":`)
+	writeJSON(buf, strings.ToLower(e.Level.String()))
+	buf.WriteString(`,"msg":`)
+	writeJSON(buf, e.Message)
+	for _, f := range e.Fields {
+		buf.WriteByte(',')
+		writeJSON(buf, f.Key)
+		buf.WriteByte(':')
+		switch v := f.Value.(type) {
+		case error, time.Duration, fmt.Stringer:
+			writeJSON(buf, formatValue(v))
+		default:
+			if b, err := json.Marshal(v); err == nil {
+				buf.Write(b)
+			} else {
+				writeJSON(buf, formatValue(v))
+			}
+		}
+	}
+	buf.WriteByte('}')
+}
+
+func writeJSON(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
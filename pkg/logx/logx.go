@@ -0,0 +1,111 @@
+package logx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", s)
+}
+
+//Field is a key/value pair of an entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+//Encoder serialize entry into a line
+type Encoder interface {
+	Encode(buf *bytes.Buffer, e *Entry)
+}
+
+//Logger write leveled entries, safe for concurrent use
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	enc    Encoder
+	level  Level
+	fields []Field
+}
+
+func New(out io.Writer, enc Encoder, level Level) *Logger {
+	return &Logger{mu: new(sync.Mutex), out: out, enc: enc, level: level}
+}
+
+//Discard drop every entry
+func Discard() *Logger {
+	return New(ioutil.Discard, TextEncoder{}, LevelError+1)
+}
+
+//With return a logger adding fields to every entry, sharing output of l
+func (l *Logger) With(fields ...Field) *Logger {
+	c := *l
+	c.fields = append(append([]Field(nil), l.fields...), fields...)
+	return &c
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	e := Entry{Time: time.Now(), Level: level, Message: msg, Fields: fields}
+	if len(l.fields) > 0 {
+		e.Fields = append(append([]Field(nil), l.fields...), fields...)
+	}
+
+	var buf bytes.Buffer
+	l.enc.Encode(&buf, &e)
+	buf.WriteByte('\n')
+	l.mu.Lock()
+	_, _ = l.out.Write(buf.Bytes())
+	l.mu.Unlock()
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) { l.Log(LevelDebug, msg, fields...) }
+func (l *Logger) Info(msg string, fields ...Field)  { l.Log(LevelInfo, msg, fields...) }
+func (l *Logger) Warn(msg string, fields ...Field)  { l.Log(LevelWarn, msg, fields...) }
+func (l *Logger) Error(msg string, fields ...Field) { l.Log(LevelError, msg, fields...) }
@@ -0,0 +1,342 @@
+//Package metax filter and edit EXIF and XMP metadata chunks before they are embedded into WebP
+package metax
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+)
+
+const (
+	TagImageDescription   uint16 = 0x010e
+	TagStripOffsets       uint16 = 0x0111
+	TagStripByteCounts    uint16 = 0x0117
+	TagArtist             uint16 = 0x013b
+	TagJPEGInterchange    uint16 = 0x0201
+	TagJPEGInterchangeLen uint16 = 0x0202
+	TagCopyright          uint16 = 0x8298
+	TagExifIFD            uint16 = 0x8769
+	TagGPSIFD             uint16 = 0x8825
+	TagMakerNote          uint16 = 0x927c
+	TagInteropIFD         uint16 = 0xa005
+	TagCameraOwnerName    uint16 = 0xa430
+	TagBodySerialNumber   uint16 = 0xa431
+	TagLensSerialNumber   uint16 = 0xa435
+	TagCameraSerialNumber uint16 = 0xc62f
+)
+
+const (
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+	typeIFD   = 13
+)
+
+//exifHeader prefix EXIF chunk copied from JPEG APP1 segment
+const exifHeader = "Exif\x00\x00"
+
+//typeSizes is byte size of TIFF field types
+var typeSizes = [...]uint64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+//subIFDTags point to IFDs nested in EXIF
+var subIFDTags = map[uint16]bool{TagExifIFD: true, TagGPSIFD: true, TagInteropIFD: true}
+
+//dataTags point to data blocks whose length is in the paired tag
+var dataTags = map[uint16]uint16{TagJPEGInterchange: TagJPEGInterchangeLen, TagStripOffsets: TagStripByteCounts}
+
+const maxIFDDepth = 4
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte   //raw value in byte order of the EXIF
+	sub   *ifd     //nested IFD of subIFDTags
+	data  [][]byte //blocks of dataTags
+}
+
+type ifd struct {
+	entries []ifdEntry
+}
+
+//Exif is a parsed EXIF TIFF structure, IFD0 and the thumbnail IFD1 with their nested IFDs
+type Exif struct {
+	order  binary.ByteOrder
+	header bool //data is prefixed by exifHeader
+	ifd0   *ifd
+	ifd1   *ifd
+}
+
+//ParseExif parse EXIF chunk, with or without the "Exif\0\0" prefix
+func ParseExif(data []byte) (*Exif, error) {
+	x := &Exif{}
+	if bytes.HasPrefix(data, []byte(exifHeader)) {
+		x.header, data = true, data[len(exifHeader):]
+	}
+	if len(data) < 8 {
+		return nil, errors.New("exif too short")
+	}
+	switch string(data[:2]) {
+	case "II":
+		x.order = binary.LittleEndian
+	case "MM":
+		x.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid exif byte order")
+	}
+	if x.order.Uint16(data[2:]) != 42 {
+		return nil, errors.New("invalid exif magic")
+	}
+
+	p := &exifParser{order: x.order, data: data, seen: map[uint32]bool{}}
+	var (
+		next uint32
+		err  error
+	)
+	if x.ifd0, next, err = p.parseIFD(x.order.Uint32(data[4:]), 0); err != nil {
+		return nil, err
+	}
+	if next != 0 {
+		//broken thumbnail is dropped rather than failing whole EXIF
+		if x.ifd1, _, err = p.parseIFD(next, 0); err != nil {
+			x.ifd1 = nil
+		}
+	}
+	return x, nil
+}
+
+type exifParser struct {
+	order binary.ByteOrder
+	data  []byte
+	seen  map[uint32]bool
+}
+
+func (p *exifParser) slice(off, size uint64) ([]byte, error) {
+	if off > uint64(len(p.data)) || size > uint64(len(p.data))-off {
+		return nil, errors.New("exif offset out of range")
+	}
+	return p.data[off : off+size], nil
+}
+
+func (p *exifParser) parseIFD(off uint32, depth int) (*ifd, uint32, error) {
+	if depth > maxIFDDepth || p.seen[off] {
+		return nil, 0, errors.New("exif IFD loop")
+	}
+	p.seen[off] = true
+	head, err := p.slice(uint64(off), 2)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := uint64(p.order.Uint16(head))
+	table, err := p.slice(uint64(off)+2, n*12+4)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d := &ifd{}
+	for i := uint64(0); i < n; i++ {
+		raw := table[i*12 : i*12+12]
+		e := ifdEntry{tag: p.order.Uint16(raw), typ: p.order.Uint16(raw[2:]), count: p.order.Uint32(raw[4:])}
+		if int(e.typ) >= len(typeSizes) || typeSizes[e.typ] == 0 {
+			//size of unknown type is unknown, it can not be moved
+			continue
+		}
+		size := typeSizes[e.typ] * uint64(e.count)
+		if size <= 4 {
+			e.value = append([]byte(nil), raw[8:8+size]...)
+		} else if e.value, err = p.slice(uint64(p.order.Uint32(raw[8:])), size); err != nil {
+			return nil, 0, err
+		}
+
+		if subIFDTags[e.tag] && e.count == 1 && (e.typ == typeLong || e.typ == typeIFD) {
+			if e.sub, _, err = p.parseIFD(p.order.Uint32(e.value), depth+1); err != nil {
+				return nil, 0, errors.WithMessagef(err, "invalid sub IFD 0x%04x", e.tag)
+			}
+		}
+		d.entries = append(d.entries, e)
+	}
+
+	//data blocks are read after all entries since lengths may follow offsets
+	for i := range d.entries {
+		e := &d.entries[i]
+		lenTag, ok := dataTags[e.tag]
+		if !ok {
+			continue
+		}
+		lens := d.find(lenTag)
+		if lens == nil || lens.count != e.count {
+			return nil, 0, errors.Errorf("exif tag 0x%04x without length", e.tag)
+		}
+		for j := 0; j < int(e.count); j++ {
+			off, err := p.uint(e, j)
+			if err != nil {
+				return nil, 0, err
+			}
+			size, err := p.uint(lens, j)
+			if err != nil {
+				return nil, 0, err
+			}
+			block, err := p.slice(off, size)
+			if err != nil {
+				return nil, 0, err
+			}
+			e.data = append(e.data, block)
+		}
+	}
+	return d, p.order.Uint32(table[n*12:]), nil
+}
+
+//uint return i-th value of SHORT or LONG entry, other types are invalid for offsets and lengths
+func (p *exifParser) uint(e *ifdEntry, i int) (uint64, error) {
+	if e.typ != typeShort && e.typ != typeLong {
+		return 0, errors.Errorf("exif tag 0x%04x of type %d is not SHORT or LONG", e.tag, e.typ)
+	}
+	size := int(typeSizes[e.typ])
+	if len(e.value) < (i+1)*size {
+		return 0, errors.Errorf("exif tag 0x%04x value too short", e.tag)
+	}
+	if e.typ == typeShort {
+		return uint64(p.order.Uint16(e.value[i*size:])), nil
+	}
+	return uint64(p.order.Uint32(e.value[i*size:])), nil
+}
+
+func (d *ifd) find(tag uint16) *ifdEntry {
+	for i := range d.entries {
+		if d.entries[i].tag == tag {
+			return &d.entries[i]
+		}
+	}
+	return nil
+}
+
+//remove drop entries matched in d and its nested IFDs
+func (d *ifd) remove(drop func(tag uint16) bool) {
+	entries := d.entries[:0]
+	for _, e := range d.entries {
+		if drop(e.tag) {
+			continue
+		}
+		if e.sub != nil {
+			e.sub.remove(drop)
+		}
+		entries = append(entries, e)
+	}
+	d.entries = entries
+}
+
+//set replace entry of the same tag or insert e keeping entries sorted by tag
+func (d *ifd) set(e ifdEntry) {
+	i := 0
+	for ; i < len(d.entries) && d.entries[i].tag < e.tag; i++ {
+	}
+	if i < len(d.entries) && d.entries[i].tag == e.tag {
+		d.entries[i] = e
+		return
+	}
+	d.entries = append(d.entries, ifdEntry{})
+	copy(d.entries[i+1:], d.entries[i:])
+	d.entries[i] = e
+}
+
+//NewExif return empty little endian EXIF with the "Exif\0\0" prefix
+func NewExif() *Exif {
+	return &Exif{order: binary.LittleEndian, header: true, ifd0: &ifd{}}
+}
+
+//SetString set ASCII tag of IFD0 such as TagArtist, s is NUL terminated
+func (x *Exif) SetString(tag uint16, s string) {
+	value := append([]byte(s), 0)
+	x.ifd0.set(ifdEntry{tag: tag, typ: typeASCII, count: uint32(len(value)), value: value})
+}
+
+//Remove drop tags matched in all IFDs, dropping a sub IFD tag such as TagGPSIFD drops the whole IFD
+func (x *Exif) Remove(drop func(tag uint16) bool) {
+	x.ifd0.remove(drop)
+	if x.ifd1 != nil {
+		x.ifd1.remove(drop)
+	}
+}
+
+//RemoveThumbnail drop IFD1 and the thumbnail image
+func (x *Exif) RemoveThumbnail() {
+	x.ifd1 = nil
+}
+
+//Bytes serialize EXIF, offsets are rebuilt so removed values leave no trace
+func (x *Exif) Bytes() []byte {
+	w := &exifWriter{order: x.order}
+	if x.header {
+		w.buf = append(w.buf, exifHeader...)
+		w.base = len(exifHeader)
+	}
+	if x.order == binary.LittleEndian {
+		w.buf = append(w.buf, 'I', 'I', 42, 0, 8, 0, 0, 0)
+	} else {
+		w.buf = append(w.buf, 'M', 'M', 0, 42, 0, 0, 0, 8)
+	}
+	_, next := w.writeIFD(x.ifd0)
+	if x.ifd1 != nil {
+		off, _ := w.writeIFD(x.ifd1)
+		x.order.PutUint32(w.buf[w.base+next:], uint32(off))
+	}
+	return w.buf
+}
+
+type exifWriter struct {
+	order binary.ByteOrder
+	buf   []byte
+	base  int //offsets are relative to TIFF header after the optional prefix
+}
+
+//alloc append n zero bytes at word boundary and return their offset
+func (w *exifWriter) alloc(n int) int {
+	if (len(w.buf)-w.base)%2 == 1 {
+		w.buf = append(w.buf, 0)
+	}
+	off := len(w.buf) - w.base
+	w.buf = append(w.buf, make([]byte, n)...)
+	return off
+}
+
+func (w *exifWriter) put(off int, typ uint16, v int) {
+	if typ == typeShort {
+		w.order.PutUint16(w.buf[w.base+off:], uint16(v))
+	} else {
+		w.order.PutUint32(w.buf[w.base+off:], uint32(v))
+	}
+}
+
+//writeIFD append d, its values, nested IFDs and data blocks, return offset of d and its next IFD pointer
+func (w *exifWriter) writeIFD(d *ifd) (int, int) {
+	var (
+		n         = len(d.entries)
+		off       = w.alloc(2 + 12*n + 4)
+		valueOffs = make([]int, n)
+	)
+	w.order.PutUint16(w.buf[w.base+off:], uint16(n))
+	for i, e := range d.entries {
+		p := off + 2 + 12*i
+		w.order.PutUint16(w.buf[w.base+p:], e.tag)
+		w.order.PutUint16(w.buf[w.base+p+2:], e.typ)
+		w.order.PutUint32(w.buf[w.base+p+4:], e.count)
+		valueOffs[i] = p + 8
+		if len(e.value) > 4 {
+			valueOffs[i] = w.alloc(len(e.value))
+			w.order.PutUint32(w.buf[w.base+p+8:], uint32(valueOffs[i]))
+		}
+		copy(w.buf[w.base+valueOffs[i]:], e.value)
+	}
+	for i, e := range d.entries {
+		if e.sub != nil {
+			sub, _ := w.writeIFD(e.sub)
+			w.put(valueOffs[i], typeLong, sub)
+		}
+		for j, block := range e.data {
+			bo := w.alloc(len(block))
+			copy(w.buf[w.base+bo:], block)
+			w.put(valueOffs[i]+j*int(typeSizes[e.typ]), e.typ, bo)
+		}
+	}
+	return off, off + 2 + 12*n
+}
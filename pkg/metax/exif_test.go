@@ -0,0 +1,146 @@
+package metax
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value uint32 //inline value or offset
+}
+
+//buildTIFF build little endian TIFF with IFD0 at offset 8 followed by extra bytes
+func buildTIFF(entries []testEntry, next uint32, extra []byte) []byte {
+	le := binary.LittleEndian
+	b := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	b = append(b, 0, 0)
+	le.PutUint16(b[8:], uint16(len(entries)))
+	for _, e := range entries {
+		raw := make([]byte, 12)
+		le.PutUint16(raw, e.tag)
+		le.PutUint16(raw[2:], e.typ)
+		le.PutUint32(raw[4:], e.count)
+		le.PutUint32(raw[8:], e.value)
+		b = append(b, raw...)
+	}
+	b = append(b, 0, 0, 0, 0)
+	le.PutUint32(b[len(b)-4:], next)
+	return append(b, extra...)
+}
+
+//ifdEnd is offset right after IFD0 of n entries built by buildTIFF
+func ifdEnd(n int) uint32 {
+	return uint32(8 + 2 + 12*n + 4)
+}
+
+func TestParseExifMalformed(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"short", []byte("II*\x00")},
+		{"byte order", []byte("XX*\x00\x08\x00\x00\x00")},
+		{"magic", []byte("II\x2b\x00\x08\x00\x00\x00")},
+		{"ifd offset out of range", []byte("II*\x00\xff\x00\x00\x00")},
+		{"truncated table", buildTIFF([]testEntry{{TagArtist, typeASCII, 4, 0}}, 0, nil)[:20]},
+		{"value out of range", buildTIFF([]testEntry{{TagArtist, typeASCII, 100, 1000}}, 0, nil)},
+		{"strip offsets of BYTE", buildTIFF([]testEntry{
+			{TagStripOffsets, 1, 1, 0},
+			{TagStripByteCounts, 1, 1, 0},
+		}, 0, nil)},
+		{"strip offsets of ASCII", buildTIFF([]testEntry{
+			{TagStripOffsets, typeASCII, 2, 0},
+			{TagStripByteCounts, typeASCII, 2, 0},
+		}, 0, nil)},
+		{"strip offsets without length", buildTIFF([]testEntry{{TagStripOffsets, typeLong, 1, 0}}, 0, nil)},
+		{"strip out of range", buildTIFF([]testEntry{
+			{TagStripOffsets, typeLong, 1, 1000},
+			{TagStripByteCounts, typeLong, 1, 10},
+		}, 0, nil)},
+		{"sub IFD loop", buildTIFF([]testEntry{{TagExifIFD, typeLong, 1, 8}}, 0, nil)},
+		{"sub IFD out of range", buildTIFF([]testEntry{{TagExifIFD, typeLong, 1, 1000}}, 0, nil)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := ParseExif(c.data); err == nil {
+				t.Fatal("expect error")
+			}
+		})
+	}
+}
+
+func TestParseExifBrokenThumbnail(t *testing.T) {
+	//IFD1 pointing back to IFD0 is dropped rather than failing
+	x, err := ParseExif(buildTIFF([]testEntry{{TagArtist, typeASCII, 2, 'a'}}, 8, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ifd1 != nil {
+		t.Fatal("expect thumbnail dropped")
+	}
+}
+
+func TestExifRemove(t *testing.T) {
+	gps := ifdEnd(3)
+	data := buildTIFF([]testEntry{
+		{TagArtist, typeASCII, 2, 'a'},
+		{TagGPSIFD, typeLong, 1, gps},
+		{TagBodySerialNumber, typeASCII, 2, 's'},
+	}, 0, []byte{1, 0, 1, 0, typeLong, 0, 1, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0})
+	data = append([]byte(exifHeader), data...)
+
+	x, err := ParseExif(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ifd0.find(TagGPSIFD).sub == nil {
+		t.Fatal("expect GPS IFD parsed")
+	}
+	x.Remove(func(tag uint16) bool {
+		return tag == TagGPSIFD || tag == TagBodySerialNumber
+	})
+	out := x.Bytes()
+	if !bytes.HasPrefix(out, []byte(exifHeader)) {
+		t.Fatal("expect exif header kept")
+	}
+
+	y, err := ParseExif(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(y.ifd0.entries) != 1 || y.ifd0.entries[0].tag != TagArtist {
+		t.Fatalf("unexpected entries %+v", y.ifd0.entries)
+	}
+}
+
+func TestExifSetString(t *testing.T) {
+	x := NewExif()
+	x.SetString(TagCopyright, "(c) someone")
+	x.SetString(TagArtist, "a")
+	x.SetString(TagArtist, "an artist")
+
+	y, err := ParseExif(x.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		tag   uint16
+		value string
+	}{
+		{TagArtist, "an artist\x00"},
+		{TagCopyright, "(c) someone\x00"},
+	}
+	if len(y.ifd0.entries) != len(want) {
+		t.Fatalf("unexpected entries %+v", y.ifd0.entries)
+	}
+	for i, w := range want {
+		e := y.ifd0.entries[i]
+		if e.tag != w.tag || e.typ != typeASCII || string(e.value) != w.value {
+			t.Errorf("entry %d: got 0x%04x %q, want 0x%04x %q", i, e.tag, e.value, w.tag, w.value)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package metax
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Chunk names of metadata as returned by imagex.MetaChunks
+const (
+	ChunkICCP = "ICCP"
+	ChunkEXIF = "EXIF"
+	ChunkXMP  = "XMP"
+)
+
+//exifGroups are names of EXIF tag sets accepted by ParsePolicy
+var exifGroups = map[string][]uint16{
+	"gps":       {TagGPSIFD},
+	"makernote": {TagMakerNote},
+	"serial":    {TagBodySerialNumber, TagLensSerialNumber, TagCameraSerialNumber, TagCameraOwnerName},
+}
+
+//Policy select metadata chunks copied into WebP, and EXIF tags and XMP properties removed from them
+type Policy struct {
+	Chunks        map[string]bool //names of chunks kept
+	EXIFTags      map[uint16]bool //tags removed from all IFDs
+	DropThumbnail bool
+	XMP           XMPNameMatcher //properties removed, nil for none
+	xmpPatterns   []string
+}
+
+//ParsePolicy build policy from chunk names kept (iccp, exif, xmp), EXIF tags removed
+//(gps, makernote, serial, thumbnail or tag number such as 0x927c) and XMP property patterns removed
+func ParsePolicy(chunks, exifTags, xmpProps []string) (*Policy, error) {
+	p := &Policy{Chunks: map[string]bool{}, EXIFTags: map[uint16]bool{}}
+	for _, name := range chunks {
+		switch upper := strings.ToUpper(name); upper {
+		case ChunkICCP, ChunkEXIF, ChunkXMP:
+			p.Chunks[upper] = true
+		default:
+			return nil, errors.New("unknown metadata chunk: " + name)
+		}
+	}
+
+	for _, name := range exifTags {
+		name = strings.ToLower(name)
+		if tags, ok := exifGroups[name]; ok {
+			for _, tag := range tags {
+				p.EXIFTags[tag] = true
+			}
+			continue
+		}
+		if name == "thumbnail" {
+			p.DropThumbnail = true
+			continue
+		}
+		tag, err := strconv.ParseUint(name, 0, 16)
+		if err != nil {
+			return nil, errors.New("invalid EXIF tag: " + name)
+		}
+		p.EXIFTags[uint16(tag)] = true
+	}
+
+	if len(xmpProps) != 0 {
+		var err error
+		if p.XMP, err = NewXMPNameMatcher(xmpProps); err != nil {
+			return nil, err
+		}
+		p.xmpPatterns = append([]string(nil), xmpProps...)
+		sort.Strings(p.xmpPatterns)
+	}
+	return p, nil
+}
+
+//String describe policy stably, policies of the same effect on chunks are equal strings
+func (p *Policy) String() string {
+	chunks := make([]string, 0, len(p.Chunks))
+	for name, ok := range p.Chunks {
+		if ok {
+			chunks = append(chunks, name)
+		}
+	}
+	sort.Strings(chunks)
+	tags := make([]string, 0, len(p.EXIFTags))
+	for tag, ok := range p.EXIFTags {
+		if ok {
+			tags = append(tags, fmt.Sprintf("0x%04x", tag))
+		}
+	}
+	sort.Strings(tags)
+	return fmt.Sprintf("chunks=%s exif=%s thumbnail=%v xmp=%s",
+		strings.Join(chunks, ","), strings.Join(tags, ","), !p.DropThumbnail, strings.Join(p.xmpPatterns, ","))
+}
+
+//Apply return chunk of name filtered by policy, nil if the chunk is not kept
+func (p *Policy) Apply(name string, chunk []byte) ([]byte, error) {
+	if !p.Chunks[name] {
+		return nil, nil
+	}
+	switch name {
+	case ChunkEXIF:
+		if len(p.EXIFTags) == 0 && !p.DropThumbnail {
+			return chunk, nil
+		}
+		x, err := ParseExif(chunk)
+		if err != nil {
+			return nil, err
+		}
+		x.Remove(func(tag uint16) bool {
+			return p.EXIFTags[tag]
+		})
+		if p.DropThumbnail {
+			x.RemoveThumbnail()
+		}
+		return x.Bytes(), nil
+	case ChunkXMP:
+		if p.XMP == nil {
+			return chunk, nil
+		}
+		return FilterXMP(chunk, p.XMP)
+	}
+	return chunk, nil
+}
@@ -0,0 +1,68 @@
+package metax
+
+import (
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	cases := []struct {
+		chunks, exifTags, xmpProps []string
+		want                       string //String of policy, empty if error expected
+	}{
+		{[]string{"iccp", "EXIF", "xmp"}, nil, nil, "chunks=EXIF,ICCP,XMP exif= thumbnail=true xmp="},
+		{[]string{"exif"}, []string{"gps", "thumbnail"}, nil, "chunks=EXIF exif=0x8825 thumbnail=false xmp="},
+		{[]string{"exif"}, []string{"serial"}, nil, "chunks=EXIF exif=0xa430,0xa431,0xa435,0xc62f thumbnail=true xmp="},
+		{[]string{"exif"}, []string{"0x927C", "makernote"}, nil, "chunks=EXIF exif=0x927c thumbnail=true xmp="},
+		{[]string{"xmp"}, nil, []string{"exif:GPS*", "aux:SerialNumber"}, "chunks=XMP exif= thumbnail=true xmp=aux:SerialNumber,exif:GPS*"},
+		{[]string{"png"}, nil, nil, ""},
+		{nil, []string{"0x10000"}, nil, ""},
+		{nil, []string{"location"}, nil, ""},
+		{nil, nil, []string{"exif:[GPS"}, ""},
+	}
+	for _, c := range cases {
+		p, err := ParsePolicy(c.chunks, c.exifTags, c.xmpProps)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%v %v %v: expect error", c.chunks, c.exifTags, c.xmpProps)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %v %v: %v", c.chunks, c.exifTags, c.xmpProps, err)
+		} else if got := p.String(); got != c.want {
+			t.Errorf("%v %v %v: got %q, want %q", c.chunks, c.exifTags, c.xmpProps, got, c.want)
+		}
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	p, err := ParsePolicy([]string{"exif", "xmp"}, []string{"gps"}, []string{"exif:GPS*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := p.Apply(ChunkICCP, []byte("icc")); err != nil || out != nil {
+		t.Errorf("ICCP: expect dropped, got %q %v", out, err)
+	}
+
+	exif := buildTIFF([]testEntry{
+		{TagArtist, typeASCII, 2, 'a'},
+		{TagGPSIFD, typeLong, 1, ifdEnd(2)},
+	}, 0, []byte{0, 0, 0, 0, 0, 0})
+	out, err := p.Apply(ChunkEXIF, exif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := ParseExif(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ifd0.find(TagGPSIFD) != nil || x.ifd0.find(TagArtist) == nil {
+		t.Errorf("unexpected entries %+v", x.ifd0.entries)
+	}
+
+	xmp := `<rdf:Description xmlns:exif="e" exif:GPSLatitude="1" exif:Make="m"><exif:GPSLongitude>2</exif:GPSLongitude></rdf:Description>`
+	want := `<rdf:Description xmlns:exif="e" exif:Make="m"></rdf:Description>`
+	if out, err = p.Apply(ChunkXMP, []byte(xmp)); err != nil || string(out) != want {
+		t.Errorf("XMP: got %q %v, want %q", out, err, want)
+	}
+}
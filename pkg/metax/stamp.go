@@ -0,0 +1,116 @@
+package metax
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+)
+
+//Stamp is attribution written into EXIF and XMP, overriding values copied from the source
+type Stamp struct {
+	Artist      string `json:"artist,omitempty"`      //EXIF Artist, XMP dc:creator
+	Copyright   string `json:"copyright,omitempty"`   //EXIF Copyright, XMP dc:rights and xmpRights:Marked
+	Description string `json:"description,omitempty"` //EXIF ImageDescription, XMP dc:description
+	License     string `json:"license,omitempty"`     //XMP xmpRights:WebStatement, URL of the license
+	UsageTerms  string `json:"usage_terms,omitempty"` //XMP xmpRights:UsageTerms
+}
+
+//LoadStamp read stamp from JSON file
+func LoadStamp(file string) (*Stamp, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s := &Stamp{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrapf(err, "invalid stamp file %s", file)
+	}
+	return s, nil
+}
+
+//Merge return copy of s with fields overridden by non-empty fields of o
+func (s Stamp) Merge(o Stamp) Stamp {
+	for _, f := range []struct{ dst, src *string }{
+		{&s.Artist, &o.Artist},
+		{&s.Copyright, &o.Copyright},
+		{&s.Description, &o.Description},
+		{&s.License, &o.License},
+		{&s.UsageTerms, &o.UsageTerms},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	return s
+}
+
+//Empty report whether nothing is stamped
+func (s Stamp) Empty() bool {
+	return s == Stamp{}
+}
+
+//Apply return chunk of name with stamp written, a new chunk is created if chunk is nil and s has fields for it
+func (s *Stamp) Apply(name string, chunk []byte) ([]byte, error) {
+	switch name {
+	case ChunkEXIF:
+		return s.applyExif(chunk)
+	case ChunkXMP:
+		return s.applyXMP(chunk)
+	}
+	return chunk, nil
+}
+
+func (s *Stamp) applyExif(chunk []byte) ([]byte, error) {
+	tags := []struct {
+		tag   uint16
+		value string
+	}{
+		{TagImageDescription, s.Description},
+		{TagArtist, s.Artist},
+		{TagCopyright, s.Copyright},
+	}
+	var x *Exif
+	for _, t := range tags {
+		if t.value == "" {
+			continue
+		}
+		if x == nil {
+			if chunk == nil {
+				x = NewExif()
+			} else if p, err := ParseExif(chunk); err != nil {
+				return nil, err
+			} else {
+				x = p
+			}
+		}
+		x.SetString(t.tag, t.value)
+	}
+	if x == nil {
+		return chunk, nil
+	}
+	return x.Bytes(), nil
+}
+
+func (s *Stamp) applyXMP(chunk []byte) ([]byte, error) {
+	var props []XMPProp
+	if s.Artist != "" {
+		props = append(props, XMPSeq("dc:creator", s.Artist))
+	}
+	if s.Copyright != "" {
+		props = append(props, XMPAlt("dc:rights", LangText{Text: s.Copyright}))
+		props = append(props, XMPText("xmpRights:Marked", "True"))
+	}
+	if s.Description != "" {
+		props = append(props, XMPAlt("dc:description", LangText{Text: s.Description}))
+	}
+	if s.License != "" {
+		props = append(props, XMPText("xmpRights:WebStatement", s.License))
+	}
+	if s.UsageTerms != "" {
+		props = append(props, XMPAlt("xmpRights:UsageTerms", LangText{Text: s.UsageTerms}))
+	}
+	if len(props) == 0 {
+		return chunk, nil
+	}
+	return SetXMP(chunk, props...)
+}
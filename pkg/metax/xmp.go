@@ -0,0 +1,325 @@
+package metax
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"strings"
+)
+
+//XMPNameMatcher report whether XMP property of qualified name such as "exif:GPSLatitude" matched
+type XMPNameMatcher func(name string) bool
+
+//NewXMPNameMatcher match qualified names by glob patterns, e.g. "exif:GPS*", "aux:SerialNumber"
+func NewXMPNameMatcher(patterns []string) (XMPNameMatcher, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, "foo:bar"); err != nil {
+			return nil, errors.Wrapf(err, "invalid XMP property pattern <%s>", p)
+		}
+	}
+	return func(name string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+//FilterXMP remove properties matched by drop from XMP packet, both element and attribute forms.
+//Properties are matched by qualified names as written in the packet, namespace declarations are kept.
+func FilterXMP(data []byte, drop XMPNameMatcher) ([]byte, error) {
+	out, _, err := editXMP(data, drop, nil)
+	return out, err
+}
+
+//xmpNamespaces are namespaces of prefixes accepted by XMPProp builders
+var xmpNamespaces = map[string]string{
+	"dc":        "http://purl.org/dc/elements/1.1/",
+	"xmp":       "http://ns.adobe.com/xap/1.0/",
+	"xmpRights": "http://ns.adobe.com/xap/1.0/rights/",
+	"photoshop": "http://ns.adobe.com/photoshop/1.0/",
+}
+
+//xmpTemplate is an empty packet
+const xmpTemplate = "<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" +
+	"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\"><rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">" +
+	"<rdf:Description rdf:about=\"\"></rdf:Description></rdf:RDF></x:xmpmeta>\n" +
+	"<?xpacket end=\"w\"?>"
+
+//XMPProp is a property element written by SetXMP and MergeXMP
+type XMPProp struct {
+	name   string //qualified name
+	prefix string
+	xml    string
+}
+
+//LangText is an item of language alternative, empty Lang for x-default
+type LangText struct {
+	Lang string
+	Text string
+}
+
+func newXMPProp(name string, b *bytes.Buffer) XMPProp {
+	prefix := name
+	if idx := strings.IndexByte(name, ':'); idx != -1 {
+		prefix = name[:idx]
+	}
+	return XMPProp{name: name, prefix: prefix, xml: b.String()}
+}
+
+//XMPText build simple property, prefix of name is one of dc, xmp, xmpRights, photoshop
+func XMPText(name, value string) XMPProp {
+	var b bytes.Buffer
+	b.WriteString("<" + name + ">")
+	escapeXML(&b, []byte(value), false)
+	b.WriteString("</" + name + ">")
+	return newXMPProp(name, &b)
+}
+
+//XMPSeq build ordered array property
+func XMPSeq(name string, values ...string) XMPProp {
+	var b bytes.Buffer
+	b.WriteString("<" + name + "><rdf:Seq>")
+	for _, v := range values {
+		b.WriteString("<rdf:li>")
+		escapeXML(&b, []byte(v), false)
+		b.WriteString("</rdf:li>")
+	}
+	b.WriteString("</rdf:Seq></" + name + ">")
+	return newXMPProp(name, &b)
+}
+
+//XMPAlt build language alternative property, the first item is also the default if none is x-default
+func XMPAlt(name string, texts ...LangText) XMPProp {
+	var b bytes.Buffer
+	b.WriteString("<" + name + "><rdf:Alt>")
+	hasDefault := false
+	for _, t := range texts {
+		hasDefault = hasDefault || t.Lang == "" || t.Lang == "x-default"
+	}
+	for i, t := range texts {
+		if t.Lang == "" {
+			t.Lang = "x-default"
+		}
+		if i == 0 && !hasDefault {
+			writeXMPLangItem(&b, "x-default", t.Text)
+		}
+		writeXMPLangItem(&b, t.Lang, t.Text)
+	}
+	b.WriteString("</rdf:Alt></" + name + ">")
+	return newXMPProp(name, &b)
+}
+
+func writeXMPLangItem(w *bytes.Buffer, lang, text string) {
+	w.WriteString("<rdf:li xml:lang=\"")
+	escapeXML(w, []byte(lang), true)
+	w.WriteString("\">")
+	escapeXML(w, []byte(text), false)
+	w.WriteString("</rdf:li>")
+}
+
+//SetXMP write props into packet, replacing existing properties of the same names,
+//a new packet is created if data is nil or has no rdf:Description
+func SetXMP(data []byte, props ...XMPProp) ([]byte, error) {
+	names := map[string]bool{}
+	for _, p := range props {
+		names[p.name] = true
+	}
+	drop := func(name string) bool {
+		return names[name]
+	}
+	if data != nil {
+		out, ok, err := editXMP(data, drop, props)
+		if err != nil || ok {
+			return out, err
+		}
+	}
+	out, _, err := editXMP([]byte(xmpTemplate), drop, props)
+	return out, err
+}
+
+//MergeXMP write props missing in packet, existing properties of the same names are kept
+func MergeXMP(data []byte, props ...XMPProp) ([]byte, error) {
+	if data != nil {
+		names, err := xmpNames(data)
+		if err != nil {
+			return nil, err
+		}
+		missing := props[:0:0]
+		for _, p := range props {
+			if !names[p.name] {
+				missing = append(missing, p)
+			}
+		}
+		if len(missing) == 0 {
+			return data, nil
+		}
+		props = missing
+	}
+	return SetXMP(data, props...)
+}
+
+//xmpNames return qualified names of all elements and attributes in packet
+func xmpNames(data []byte) (map[string]bool, error) {
+	var (
+		dec   = xml.NewDecoder(bytes.NewReader(data))
+		names = map[string]bool{}
+	)
+	dec.Strict = false
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return names, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "invalid XMP")
+		}
+		if t, ok := tok.(xml.StartElement); ok {
+			names[qualifiedName(t.Name)] = true
+			for _, attr := range t.Attr {
+				names[qualifiedName(attr.Name)] = true
+			}
+		}
+	}
+}
+
+//editXMP remove properties matched by drop, then append props to the first rdf:Description
+//and declare their namespaces on it, false is returned if there is no rdf:Description
+func editXMP(data []byte, drop XMPNameMatcher, props []XMPProp) ([]byte, bool, error) {
+	var (
+		dec      = xml.NewDecoder(bytes.NewReader(data))
+		out      bytes.Buffer
+		skip     int //depth inside dropped element
+		desc     int //depth inside the first rdf:Description, 0 outside
+		appended bool
+	)
+	dec.Strict = false
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false, errors.Wrap(err, "invalid XMP")
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 || drop(qualifiedName(t.Name)) {
+				skip++
+				continue
+			}
+			attrs := t.Attr[:0]
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Space == "rdf" || attr.Name.Space == "xml" || !drop(qualifiedName(attr.Name)) {
+					attrs = append(attrs, attr)
+				}
+			}
+			t.Attr = attrs
+			if desc > 0 {
+				desc++
+			} else if !appended && qualifiedName(t.Name) == "rdf:Description" {
+				desc = 1
+				t.Attr = declareNamespaces(t.Attr, props)
+			}
+			writeXMLToken(&out, t)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if desc == 1 {
+				for _, p := range props {
+					out.WriteString(p.xml)
+				}
+				appended = true
+			}
+			if desc > 0 {
+				desc--
+			}
+			writeXMLToken(&out, t)
+		default:
+			if skip == 0 {
+				writeXMLToken(&out, t)
+			}
+		}
+	}
+	return out.Bytes(), appended, nil
+}
+
+//declareNamespaces add xmlns attributes of props not declared in attrs
+func declareNamespaces(attrs []xml.Attr, props []XMPProp) []xml.Attr {
+	declared := map[string]bool{}
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" {
+			declared[attr.Name.Local] = true
+		}
+	}
+	for _, p := range props {
+		if ns, ok := xmpNamespaces[p.prefix]; ok && !declared[p.prefix] {
+			declared[p.prefix] = true
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Space: "xmlns", Local: p.prefix}, Value: ns})
+		}
+	}
+	return attrs
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+//writeXMLToken write raw token, names keep their prefixes unlike xml.Encoder
+func writeXMLToken(w *bytes.Buffer, tok xml.Token) {
+	switch t := tok.(type) {
+	case xml.StartElement:
+		w.WriteString("<" + qualifiedName(t.Name))
+		for _, attr := range t.Attr {
+			w.WriteString(" " + qualifiedName(attr.Name) + `="`)
+			escapeXML(w, []byte(attr.Value), true)
+			w.WriteString(`"`)
+		}
+		w.WriteString(">")
+	case xml.EndElement:
+		w.WriteString("</" + qualifiedName(t.Name) + ">")
+	case xml.CharData:
+		escapeXML(w, t, false)
+	case xml.Comment:
+		w.WriteString("<!--")
+		w.Write(t)
+		w.WriteString("-->")
+	case xml.ProcInst:
+		w.WriteString("<?" + t.Target)
+		if len(t.Inst) != 0 {
+			w.WriteString(" ")
+			w.Write(t.Inst)
+		}
+		w.WriteString("?>")
+	case xml.Directive:
+		w.WriteString("<!")
+		w.Write(t)
+		w.WriteString(">")
+	}
+}
+
+//escapeXML escape markup characters only, xml.EscapeText also escapes the whitespace of packet layout
+func escapeXML(w *bytes.Buffer, s []byte, attr bool) {
+	for _, c := range s {
+		switch {
+		case c == '&':
+			w.WriteString("&amp;")
+		case c == '<':
+			w.WriteString("&lt;")
+		case c == '>':
+			w.WriteString("&gt;")
+		case c == '"' && attr:
+			w.WriteString("&quot;")
+		default:
+			w.WriteByte(c)
+		}
+	}
+}
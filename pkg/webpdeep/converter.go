@@ -0,0 +1,215 @@
+//Package webpdeep convert images, archives and directory trees into WebP, it is what the webpdeep command is built on
+package webpdeep
+
+import (
+	"context"
+	"github.com/mocukie/webpdeep/internal/coder"
+	"github.com/mocukie/webpdeep/internal/component"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/mocukie/webpdeep/pkg/metax"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//Converter convert images, archives and directory trees with fixed options
+type Converter struct {
+	opts  Options
+	meta  *metax.Policy
+	stamp *metax.Stamp
+	dedup *component.DedupCache
+
+	mu      sync.Mutex
+	running map[*component.Session]struct{}
+}
+
+func New(opts Options) (*Converter, error) {
+	conf, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	c := &Converter{opts: opts, meta: conf.MetaPolicy, stamp: conf.Stamp, running: map[*component.Session]struct{}{}}
+	if opts.Dedup || opts.DedupCache != "" {
+		//encoded zip entries kept for reuse in a run are bounded like pending ones, spilling into TempDir
+		store := iox.NewZipStore(opts.TempDir, opts.ZipMemLimit)
+		if c.dedup, err = component.NewDedupCache(opts.DedupCache, store); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Converter) Options() Options {
+	return c.opts
+}
+
+func (c *Converter) codec() *coder.WebP {
+	return &coder.WebP{Opts: c.opts.Encode, CopyMeta: c.opts.CopyImageMeta, Meta: c.meta, Stamp: c.stamp, CheckImage: c.opts.CheckImage}
+}
+
+//ConvertReader encode image read from r into w
+func (c *Converter) ConvertReader(r io.Reader, w io.Writer) (*Result, error) {
+	var (
+		codec = c.codec()
+		cr    = &countReader{Reader: r}
+		cw    = &countWriter{Writer: w}
+		start = time.Now()
+	)
+	err, warnings := codec.Convert(cr, cw)
+	res := &Result{
+		Codec:    CodecConvert,
+		Options:  codec.Opts,
+		Profile:  codec.Profile(),
+		InBytes:  cr.n,
+		OutBytes: cw.n,
+		Duration: time.Since(start),
+		Width:    codec.Width,
+		Height:   codec.Height,
+		Warnings: warnings,
+		Err:      errors.WithStack(err),
+	}
+	return res, res.Err
+}
+
+//ConvertFile encode image file src into dst atomically, empty dst for src with .webp extension.
+//"-" is standard input or output, empty dst of standard input is standard output.
+func (c *Converter) ConvertFile(src, dst string) (*Result, error) {
+	if dst == "" {
+		dst = src[:len(src)-len(filepath.Ext(src))] + ".webp"
+		if src == iox.StdPath {
+			dst = iox.StdPath
+		}
+	}
+	job := &component.Job{
+		Codec:    c.codec(),
+		CopyMeta: c.opts.CopyFileMeta,
+	}
+	if src == iox.StdPath {
+		job.In = iox.NewStdInput()
+	} else {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		job.In = iox.NewFileInput(src, info)
+	}
+	if dst == iox.StdPath {
+		job.Out = iox.NewStdOutput()
+	} else {
+		job.Out = iox.NewAtomicFileOutput(dst)
+	}
+	job.Do()
+	res := newResult(job)
+	return res, res.Err
+}
+
+//Run convert src into dst, see NewTask
+func (c *Converter) Run(ctx context.Context, src, dst string) (*Summary, error) {
+	t, err := c.NewTask(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	return c.RunTask(ctx, t)
+}
+
+//RunTask run task until all jobs done, in watch mode until ctx done and queued jobs finished.
+//Options.OnEvent receive events of jobs, the returned error is ctx error if aborted or error of saving dedup cache.
+func (c *Converter) RunTask(ctx context.Context, t *Task) (*Summary, error) {
+	var (
+		s       = t.session(c.dedup, false)
+		summary = &Summary{}
+		start   = time.Now()
+	)
+	c.track(s, true)
+	defer c.track(s, false)
+
+	s.Run(ctx, component.SessionHandler{
+		Queued: func(job *component.Job) {
+			c.emit(Event{Type: EventJobQueued, Job: queuedResult(job)})
+		},
+		Done: func(job *component.Job) {
+			r := newResult(job)
+			summary.add(r)
+			c.emit(Event{Type: EventJobDone, Job: r})
+		},
+		Error: func(err error) {
+			summary.ScanErrors++
+			c.emit(Event{Type: EventScanError, Err: err})
+		},
+	})
+	summary.Elapsed = time.Since(start)
+
+	if c.dedup != nil {
+		_ = c.dedup.Release()
+		if err := c.dedup.Save(); err != nil {
+			return summary, errors.WithMessage(err, "can not save dedup cache")
+		}
+	}
+	if !c.opts.Watch && ctx.Err() != nil {
+		return summary, ctx.Err()
+	}
+	return summary, nil
+}
+
+func (c *Converter) emit(evt Event) {
+	if c.opts.OnEvent != nil {
+		c.opts.OnEvent(evt)
+	}
+}
+
+func (c *Converter) track(s *component.Session, running bool) {
+	c.mu.Lock()
+	if running {
+		c.running[s] = struct{}{}
+	} else {
+		delete(c.running, s)
+	}
+	c.mu.Unlock()
+}
+
+//QueueDepth return number of jobs waiting in queue of running tasks
+func (c *Converter) QueueDepth() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for s := range c.running {
+		n += s.QueueDepth()
+	}
+	return n
+}
+
+//ActiveWorkers return number of workers running a job in running tasks
+func (c *Converter) ActiveWorkers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for s := range c.running {
+		n += s.ActiveWorkers()
+	}
+	return n
+}
+
+type countReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type countWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,241 @@
+package webpdeep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvertReader(t *testing.T) {
+	conv, err := New(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := testPNG(t)
+	var out bytes.Buffer
+	res, err := conv.ConvertReader(bytes.NewReader(img), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Codec != CodecConvert || res.Width != 10 || res.Height != 10 ||
+		res.InBytes != int64(len(img)) || res.OutBytes != int64(out.Len()) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	if _, err = conv.ConvertReader(strings.NewReader("not an image"), ioutil.Discard); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+//writeTree write files of relative slash path under dir
+func writeTree(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		pathname := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(pathname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pathname, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//listTree return relative slash path of files under dir
+func listTree(t *testing.T, dir string) []string {
+	var names []string
+	err := filepath.Walk(dir, func(pathname string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, pathname)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := testPNG(t)
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string][]byte{
+		"a.png":     img,
+		"b.png":     img,
+		"c.txt":     []byte("text"),
+		"d.bin":     []byte("skipped"),
+		"sub/e.png": img,
+		"in.zip":    testZip(t, map[string][]byte{"f.png": img, "g.txt": []byte("text")}),
+	})
+
+	opts := DefaultOptions()
+	opts.Recursive = true
+	opts.CopyPattern = "*.txt"
+	opts.Dedup = true
+	var (
+		events []EventType
+		sizes  []string
+	)
+	opts.OnEvent = func(evt Event) {
+		events = append(events, evt.Type)
+		if evt.Type == EventJobDone && evt.Job.Codec == CodecConvert {
+			sizes = append(sizes, fmt.Sprintf("%dx%d", evt.Job.Width, evt.Job.Height))
+		}
+	}
+	conv, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	summary, err := conv.Run(context.Background(), src, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//archive entries are jobs too, b.png and both entries are identical to earlier inputs
+	want := Summary{Jobs: 6, Converted: 4, Copied: 2, Deduped: 3}
+	if summary.Jobs != want.Jobs || summary.Converted != want.Converted || summary.Copied != want.Copied ||
+		summary.Deduped != want.Deduped || summary.Failed != 0 || summary.ScanErrors != 0 {
+		t.Errorf("got summary %+v, want %+v", summary, want)
+	}
+	if len(events) != 2*want.Jobs {
+		t.Errorf("got %d events, want %d", len(events), 2*want.Jobs)
+	}
+
+	//deduped jobs report size of the source too
+	if strings.Join(sizes, ",") != "10x10,10x10,10x10,10x10" {
+		t.Errorf("got sizes %v", sizes)
+	}
+
+	files := listTree(t, out)
+	wantFiles := []string{"a.webp", "b.webp", "c.txt", "in.zip", "sub/e.webp"}
+	if strings.Join(files, ",") != strings.Join(wantFiles, ",") {
+		t.Errorf("got outputs %v, want %v", files, wantFiles)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := testPNG(t)
+	writeTree(t, dir, map[string][]byte{
+		"src/a.png":  img,
+		"src/in.zip": testZip(t, map[string][]byte{"b.png": img, "c.png": img}),
+	})
+
+	opts := DefaultOptions()
+	opts.Recursive = true
+	conv, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := conv.NewTask(filepath.Join(dir, "src"), filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := conv.Plan(context.Background(), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Total.Convert != 3 || plan.Total.Copy != 0 || len(plan.Items) != 3 || len(plan.Errors) != 0 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if files := listTree(t, dir); len(files) != 2 {
+		t.Fatalf("plan wrote outputs %v", files)
+	}
+}
+
+func TestRunPackFileMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string][]byte{"a.png": testPNG(t)})
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	if err = os.Chmod(src, 0700); err == nil {
+		err = os.Chtimes(src, mtime, mtime)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Container = "zip"
+	opts.CopyFileMeta = true
+	conv, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.zip")
+	if _, err = conv.Run(context.Background(), src, out); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//mode of directory is not applied to zip file
+	if info.Mode()&0111 != 0 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("got mode %v mtime %v", info.Mode(), info.ModTime())
+	}
+}
+
+func TestRunInPlaceArchiveFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inplace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "in.zip")
+	data := testZip(t, map[string][]byte{"a.png": testPNG(t), "b.png": []byte("broken"), "c.png": testPNG(t)})
+	writeTree(t, dir, map[string][]byte{"in.zip": data})
+
+	opts := DefaultOptions()
+	opts.InPlace = true
+	var (
+		failed []string
+		errs   []string
+	)
+	opts.OnEvent = func(evt Event) {
+		if evt.Type == EventJobDone && evt.Job.Err != nil {
+			failed = append(failed, evt.Job.Input)
+		} else if evt.Type == EventScanError {
+			errs = append(errs, evt.Err.Error())
+		}
+	}
+	conv, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conv.Run(context.Background(), archive, ""); err != nil {
+		t.Fatal(err)
+	}
+	//only the broken entry fails, the archive is reported on its own
+	if len(failed) != 1 || failed[0] != archive+"|b.png" {
+		t.Errorf("got failed jobs %v", failed)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0], "<"+archive+">") {
+		t.Errorf("got errors %v", errs)
+	}
+	if kept, _ := ioutil.ReadFile(archive); !bytes.Equal(kept, data) {
+		t.Error("expect archive unchanged")
+	}
+}
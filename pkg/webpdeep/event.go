@@ -0,0 +1,118 @@
+package webpdeep
+
+import (
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/internal/coder"
+	"github.com/mocukie/webpdeep/internal/component"
+	"time"
+)
+
+const (
+	CodecConvert = "convert"
+	CodecCopy    = "copy"
+)
+
+type EventType int
+
+const (
+	EventJobQueued EventType = iota //Job found by scanner, only scan time fields are set
+	EventJobDone                    //Job finished, or planned in dry run
+	EventScanError                  //Err of scanner, no job is made for the path
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJobQueued:
+		return "queued"
+	case EventJobDone:
+		return "done"
+	case EventScanError:
+		return "scan-error"
+	}
+	return "unknown"
+}
+
+type Event struct {
+	Type EventType
+	Job  *Result
+	Err  error
+}
+
+//Result of a job, nested archive entries are named "archive|entry"
+type Result struct {
+	Input    string
+	Output   string
+	Codec    string              //CodecConvert or CodecCopy
+	Options  *webp.EncodeOptions //nil for copy
+	Profile  string              //brief of Options
+	Size     int64               //input size known before run, 0 if unknown
+	InBytes  int64               //bytes read from input
+	OutBytes int64               //bytes written to output
+	Duration time.Duration       //time spent in codec
+	Width    int
+	Height   int
+	UpToDate bool //output is not older than input, only planned in dry run
+	Deduped  bool //output reused from identical input
+	Warnings []error
+	Err      error
+}
+
+//newResult make result of finished job
+func newResult(job *component.Job) *Result {
+	r := queuedResult(job)
+	r.InBytes, r.OutBytes, r.Duration = job.InSize, job.OutSize, job.Elapsed
+	r.Deduped, r.Warnings, r.Err = job.Deduped, job.Warnings, job.Err
+	if c, ok := job.Codec.(*coder.WebP); ok {
+		r.Width, r.Height = c.Width, c.Height
+	}
+	return r
+}
+
+//queuedResult make result with fields known before job run
+func queuedResult(job *component.Job) *Result {
+	r := &Result{Input: job.In.Path(), Output: job.Out.Path(), UpToDate: job.UpToDate}
+	switch c := job.Codec.(type) {
+	case *coder.WebP:
+		r.Codec, r.Options, r.Profile = CodecConvert, c.Opts, c.Profile()
+	case *coder.Copy:
+		r.Codec = CodecCopy
+	}
+	if info, err := job.In.Info(); err == nil && info != nil {
+		r.Size = info.Size()
+	}
+	return r
+}
+
+//Summary of a run
+type Summary struct {
+	Jobs       int
+	Converted  int
+	Copied     int
+	Deduped    int
+	Failed     int
+	Warnings   int
+	ScanErrors int
+	InBytes    int64
+	OutBytes   int64
+	Elapsed    time.Duration
+}
+
+func (s *Summary) add(r *Result) {
+	s.Jobs++
+	s.Warnings += len(r.Warnings)
+	if r.Err != nil {
+		s.Failed++
+		return
+	}
+	switch r.Codec {
+	case CodecConvert:
+		s.Converted++
+	case CodecCopy:
+		s.Copied++
+	}
+	if r.Deduped {
+		s.Deduped++
+	}
+	s.InBytes += r.InBytes
+	s.OutBytes += r.OutBytes
+}
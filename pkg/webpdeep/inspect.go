@@ -0,0 +1,93 @@
+package webpdeep
+
+import (
+	"bytes"
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/mocukie/webpdeep/pkg/imagex"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//ImageInfo is the properties and metadata chunks of an image
+type ImageInfo struct {
+	Path      string      `json:"path"`
+	Size      int64       `json:"size"`
+	Format    string      `json:"format"`
+	Width     int         `json:"width"`
+	Height    int         `json:"height"`
+	BitDepth  int         `json:"bit_depth"`
+	ColorType string      `json:"color_type"`
+	HasAlpha  bool        `json:"alpha"`
+	Meta      []MetaChunk `json:"meta"`
+}
+
+//MetaChunk is a metadata chunk found in image, Name is one of ICCP, EXIF and XMP
+type MetaChunk struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+var metaFourCCs = [...]webp.FourCC{webp.ICCP, webp.EXIF, webp.XMP}
+
+//Inspect read properties and metadata chunks of image file, archive entry "archive|entry" or "-" for stdin
+func Inspect(pathname string) (*ImageInfo, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if pathname == iox.StdPath {
+		data, err = ioutil.ReadAll(os.Stdin)
+		err = errors.WithStack(err)
+	} else {
+		nf := newNestedFiles()
+		data, err = nf.read(pathname)
+		_ = nf.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return InspectBytes(pathname, data)
+}
+
+//InspectBytes read properties and metadata chunks of image data, path is only copied into result
+func InspectBytes(pathname string, data []byte) (*ImageInfo, error) {
+	props, err := imagex.DecodeProps(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.WithMessage(err, "unknown image format")
+	}
+	info := &ImageInfo{
+		Path:      pathname,
+		Size:      int64(len(data)),
+		Format:    props.Format,
+		Width:     props.Width,
+		Height:    props.Height,
+		BitDepth:  props.BitDepth,
+		ColorType: props.ColorType,
+		HasAlpha:  props.HasAlpha,
+		Meta:      []MetaChunk{},
+	}
+
+	//meta readers collect chunks while the image is decoded
+	var meta imagex.MetaChunks = imagex.EmptyMetaChunks{}
+	if props.Format != "webp" {
+		if _, _, m, _ := imagex.Decode(bytes.NewReader(data)); m != nil {
+			meta = m
+		}
+	}
+	for _, cc := range metaFourCCs {
+		var (
+			name  = strings.TrimRight(string(cc[:]), " ")
+			chunk = meta.Get(name)
+		)
+		if props.Format == "webp" {
+			chunk, _ = webp.GetMetadata(data, cc)
+		}
+		if chunk != nil {
+			info.Meta = append(info.Meta, MetaChunk{Name: name, Size: len(chunk)})
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,103 @@
+package webpdeep
+
+import (
+	"archive/zip"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+//nestedFiles read files and archive entries named "archive|entry", each archive is opened once
+type nestedFiles struct {
+	mu       sync.Mutex
+	archives map[string]*nestedArchive
+}
+
+type nestedArchive struct {
+	zip     *zip.ReadCloser
+	entries map[string]*zip.File
+	err     error
+}
+
+func newNestedFiles() *nestedFiles {
+	return &nestedFiles{archives: map[string]*nestedArchive{}}
+}
+
+func (nf *nestedFiles) archive(pathname string) (*nestedArchive, error) {
+	nf.mu.Lock()
+	defer nf.mu.Unlock()
+	a, ok := nf.archives[pathname]
+	if !ok {
+		a = &nestedArchive{entries: map[string]*zip.File{}}
+		if a.zip, a.err = zip.OpenReader(pathname); a.err == nil {
+			for _, entry := range a.zip.File {
+				a.entries[entry.Name] = entry
+			}
+		}
+		nf.archives[pathname] = a
+	}
+	return a, errors.WithStack(a.err)
+}
+
+func (nf *nestedFiles) entry(pathname string) (*zip.File, error) {
+	idx := strings.LastIndex(pathname, iox.NestSeparator)
+	a, err := nf.archive(pathname[:idx])
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := a.entries[pathname[idx+1:]]
+	if !ok {
+		return nil, errors.Wrapf(os.ErrNotExist, "zip entry <%s> not found", pathname)
+	}
+	return entry, nil
+}
+
+//size return size of file or uncompressed archive entry
+func (nf *nestedFiles) size(pathname string) (int64, error) {
+	if !strings.Contains(pathname, iox.NestSeparator) {
+		info, err := os.Stat(pathname)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return info.Size(), nil
+	}
+	entry, err := nf.entry(pathname)
+	if err != nil {
+		return 0, err
+	}
+	return int64(entry.UncompressedSize64), nil
+}
+
+func (nf *nestedFiles) read(pathname string) ([]byte, error) {
+	if !strings.Contains(pathname, iox.NestSeparator) {
+		data, err := ioutil.ReadFile(pathname)
+		return data, errors.WithStack(err)
+	}
+	entry, err := nf.entry(pathname)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	return data, errors.WithStack(err)
+}
+
+func (nf *nestedFiles) Close() error {
+	var err error
+	for _, a := range nf.archives {
+		if a.zip != nil {
+			if e := a.zip.Close(); e != nil && err == nil {
+				err = errors.WithStack(e)
+			}
+		}
+	}
+	nf.archives = map[string]*nestedArchive{}
+	return err
+}
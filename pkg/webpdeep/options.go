@@ -0,0 +1,228 @@
+package webpdeep
+
+import (
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/internal/component"
+	"github.com/mocukie/webpdeep/pkg/metax"
+	"github.com/mocukie/webpdeep/pkg/zipx"
+	"github.com/pkg/errors"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+//Options of Converter, start from DefaultOptions since zero value misses patterns and encode options
+type Options struct {
+	Recursive      bool
+	ConvertPattern string   //glob patterns of images to convert, separated by "|"
+	CopyPattern    string   //glob patterns of files copied as is, empty for none
+	ArchivePattern string   //glob patterns of archives whose entries are converted
+	Filters        []string //image filter expressions such as "width>=1000", all must match
+	CopyFileMeta   bool
+	CopyImageMeta  bool
+	MetaChunks     []string    //chunks copied with CopyImageMeta: iccp, exif, xmp
+	StripEXIF      []string    //EXIF tags removed: gps, makernote, serial, thumbnail or tag number such as 0x927c
+	StripXMP       []string    //glob patterns of XMP properties removed, e.g. "exif:GPS*"
+	Stamp          metax.Stamp //attribution written into every webp, overrides StampFile
+	StampFile      string      //JSON file of stamp
+	StampSidecar   string      //name of JSON stamp file looked up in source directories, nearer overrides
+	CheckImage     bool        //compare output with source in lossless mode
+	Workers        int         //concurrent jobs, <= 0 for number of CPUs
+	TempDir        string      //directory for spilled zip entries
+	ZipMemLimit    int64       //bytes of pending zip entries before spilling into TempDir
+	ZipMethods     []string    //zip entry compression rules tried before DefaultZipMethod
+	ZipCharset     string      //charset of non-utf8 zip entry names, or "auto"
+	Container      string      //auto, zip or dir
+	PackExt        string
+	Symlinks       string //files, follow, skip or preserve
+	InPlace        bool   //write output next to source
+	Original       string //keep, delete or backup source after converted in place
+	BackupDir      string
+	Verify         bool    //decode written webp before handling source in place
+	MinPSNR        float64 //lowest PSNR (dB) of lossy output accepted by Converter.Verify, 0 to skip
+	Update         bool    //skip sources whose output is not older than them
+	Dedup          bool
+	DedupCache     string //file to persist dedup cache across runs, implies Dedup
+	Watch          bool   //keep converting changes of source directory until context done
+	WatchDelay     time.Duration
+	Encode         *webp.EncodeOptions
+	OnEvent        func(Event) //called in order from a single goroutine during Run
+}
+
+//DefaultZipMethod store entries which are already compressed, it is tried after Options.ZipMethods
+const DefaultZipMethod = "*.webp|*.jpg|*.jpeg|*.png|*.gif|*.zip|*.cbz=store"
+
+func DefaultOptions() Options {
+	opts, _ := webp.NewEncOptionsByPreset(webp.PresetDefault, webp.LossyDefaultQuality)
+	return Options{
+		ConvertPattern: "*.png|*.jpg|*.bmp|*.tiff",
+		ArchivePattern: "*.zip|*.cbz",
+		MetaChunks:     []string{"iccp", "exif", "xmp"},
+		Workers:        runtime.NumCPU(),
+		TempDir:        os.TempDir(),
+		ZipMemLimit:    256 << 20,
+		ZipCharset:     "auto",
+		Container:      "auto",
+		PackExt:        ".zip",
+		Symlinks:       "files",
+		Original:       "keep",
+		WatchDelay:     2 * time.Second,
+		Encode:         opts,
+	}
+}
+
+//config validate options and build config without source and output
+func (o *Options) config() (*component.Config, error) {
+	var (
+		conf = &component.Config{
+			Recursively:   o.Recursive,
+			CopyFileMeta:  o.CopyFileMeta,
+			CopyImageMeta: o.CopyImageMeta,
+			CheckImage:    o.CheckImage,
+			MaxGo:         o.Workers,
+			TempDir:       o.TempDir,
+			ZipMemLimit:   o.ZipMemLimit,
+			PackExt:       o.PackExt,
+			InPlace:       o.InPlace,
+			BackupDir:     o.BackupDir,
+			Verify:        o.Verify,
+			Update:        o.Update,
+			Watch:         o.Watch,
+			WatchDelay:    o.WatchDelay,
+			Opts:          o.Encode,
+		}
+		err error
+	)
+
+	if o.Encode == nil {
+		return nil, errors.New("encode options not specify")
+	}
+	if err = o.Encode.Validate(); err != nil {
+		return nil, errors.WithMessage(err, "invalid encode options")
+	}
+
+	conf.ConvertMatch, err = component.NewGlobMatcher(o.ConvertPattern)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid convert pattern: "+o.ConvertPattern)
+	}
+
+	if o.CopyPattern != "" {
+		conf.CopyMatch, err = component.NewGlobMatcher(o.CopyPattern)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid copy pattern: "+o.CopyPattern)
+		}
+	}
+
+	conf.ArchiveMatch, err = component.NewGlobMatcher(o.ArchivePattern)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid archive pattern: "+o.ArchivePattern)
+	}
+
+	if len(o.Filters) != 0 {
+		if conf.Filter, err = component.NewFilter(o.Filters); err != nil {
+			return nil, err
+		}
+	}
+
+	//first matched wins, so the built-in rule is only a fallback of user rules
+	rules := append(append([]string{}, o.ZipMethods...), DefaultZipMethod)
+	for _, rule := range rules {
+		zm, err := component.NewZipMethod(rule)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid zip method: "+rule)
+		}
+		conf.ZipMethods = append(conf.ZipMethods, zm)
+	}
+
+	if conf.MetaPolicy, err = o.metaPolicy(); err != nil {
+		return nil, errors.WithMessage(err, "invalid metadata policy")
+	}
+
+	if conf.Stamp, err = o.stamp(); err != nil {
+		return nil, err
+	}
+	conf.StampSidecar = o.StampSidecar
+
+	conf.ZipCharset, err = zipx.LookupCharset(o.ZipCharset)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid zip charset: "+o.ZipCharset)
+	}
+
+	if conf.MaxGo <= 0 {
+		conf.MaxGo = runtime.NumCPU()
+	}
+
+	if o.ZipMemLimit < 0 {
+		return nil, errors.New("invalid zip memory limit: " + strconv.FormatInt(o.ZipMemLimit, 10))
+	}
+
+	switch o.Container {
+	case "auto":
+		conf.Container = component.ContainerAuto
+	case "zip":
+		conf.Container = component.ContainerZip
+	case "dir":
+		conf.Container = component.ContainerDir
+	default:
+		return nil, errors.New("invalid container: " + o.Container)
+	}
+
+	switch o.Symlinks {
+	case "files":
+		conf.Symlinks = component.SymlinkFiles
+	case "follow":
+		conf.Symlinks = component.SymlinkFollow
+	case "skip":
+		conf.Symlinks = component.SymlinkSkip
+	case "preserve":
+		conf.Symlinks = component.SymlinkPreserve
+	default:
+		return nil, errors.New("invalid symlinks: " + o.Symlinks)
+	}
+
+	switch o.Original {
+	case "keep":
+		conf.Original = component.OriginalKeep
+	case "delete":
+		conf.Original = component.OriginalDelete
+	case "backup":
+		conf.Original = component.OriginalBackup
+	default:
+		return nil, errors.New("invalid original: " + o.Original)
+	}
+
+	if conf.Watch && conf.WatchDelay <= 0 {
+		return nil, errors.New("invalid watch delay: " + conf.WatchDelay.String())
+	}
+
+	return conf, nil
+}
+
+//metaPolicy return nil if every chunk is copied as is
+func (o *Options) metaPolicy() (*metax.Policy, error) {
+	p, err := metax.ParsePolicy(o.MetaChunks, o.StripEXIF, o.StripXMP)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Chunks) == 3 && len(p.EXIFTags) == 0 && !p.DropThumbnail && p.XMP == nil {
+		return nil, nil
+	}
+	return p, nil
+}
+
+//stamp merge StampFile and Stamp, nil if nothing is stamped
+func (o *Options) stamp() (*metax.Stamp, error) {
+	s := &metax.Stamp{}
+	if o.StampFile != "" {
+		var err error
+		if s, err = metax.LoadStamp(o.StampFile); err != nil {
+			return nil, err
+		}
+	}
+	merged := s.Merge(o.Stamp)
+	if merged.Empty() {
+		return nil, nil
+	}
+	return &merged, nil
+}
@@ -0,0 +1,36 @@
+package webpdeep
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"testing"
+)
+
+func TestZipMethods(t *testing.T) {
+	cases := []struct {
+		name   string
+		rules  []string
+		entry  string
+		method uint16
+		level  int
+	}{
+		{"built-in", nil, "a.png", zip.Store, 0},
+		{"unmatched", nil, "a.txt", zip.Deflate, flate.DefaultCompression},
+		{"other user rule", []string{"*.txt=deflate:9"}, "a.png", zip.Store, 0},
+		{"user rule", []string{"*.txt=deflate:9"}, "a.txt", zip.Deflate, 9},
+		{"user rule wins", []string{"*.png=deflate:1"}, "a.png", zip.Deflate, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.ZipMethods = c.rules
+			conf, err := opts.config()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if method, level := conf.ZipMethodOf(c.entry); method != c.method || level != c.level {
+				t.Fatalf("got %d:%d, want %d:%d", method, level, c.method, c.level)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package webpdeep
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mocukie/webpdeep/internal/component"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+//PlanItem is a job would be run
+type PlanItem struct {
+	Input    string `json:"input"`
+	Output   string `json:"output"`
+	Codec    string `json:"codec"`
+	Profile  string `json:"profile,omitempty"`
+	Size     int64  `json:"size"`
+	UpToDate bool   `json:"up_to_date"`
+}
+
+//PlanGroup count jobs of an archive or directory
+type PlanGroup struct {
+	Path     string `json:"path"`
+	Convert  int    `json:"convert"`
+	Copy     int    `json:"copy"`
+	UpToDate int    `json:"up_to_date"`
+	Size     int64  `json:"size"`
+}
+
+//Plan is the jobs of a task would be run
+type Plan struct {
+	Items  []PlanItem   `json:"items"`
+	Groups []*PlanGroup `json:"groups"`
+	Total  PlanGroup    `json:"total"`
+	Errors []string     `json:"errors"`
+}
+
+//Plan scan task and collect its jobs without running them
+func (c *Converter) Plan(ctx context.Context, t *Task) (*Plan, error) {
+	var (
+		plan   = &Plan{Items: []PlanItem{}, Groups: []*PlanGroup{}, Errors: []string{}}
+		groups = map[string]*PlanGroup{}
+	)
+	t.session(nil, true).Run(ctx, component.SessionHandler{
+		Done: func(job *component.Job) {
+			item := newPlanItem(queuedResult(job))
+			plan.Items = append(plan.Items, item)
+
+			path := planGroupOf(item.Input)
+			g, ok := groups[path]
+			if !ok {
+				g = &PlanGroup{Path: path}
+				groups[path] = g
+				plan.Groups = append(plan.Groups, g)
+			}
+			g.add(&item)
+			plan.Total.add(&item)
+		},
+		Error: func(err error) {
+			plan.Errors = append(plan.Errors, fmt.Sprint(err))
+		},
+	})
+	return plan, ctx.Err()
+}
+
+func newPlanItem(r *Result) PlanItem {
+	return PlanItem{Input: r.Input, Output: r.Output, Codec: r.Codec, Profile: r.Profile, Size: r.Size, UpToDate: r.UpToDate}
+}
+
+//planGroupOf return archive of entry or directory of file
+func planGroupOf(pathname string) string {
+	if idx := strings.LastIndex(pathname, iox.NestSeparator); idx != -1 {
+		return pathname[:idx]
+	}
+	return filepath.Dir(pathname)
+}
+
+func (g *PlanGroup) add(item *PlanItem) {
+	switch {
+	case item.UpToDate:
+		g.UpToDate++
+		return
+	case item.Codec == CodecConvert:
+		g.Convert++
+	case item.Codec == CodecCopy:
+		g.Copy++
+	}
+	g.Size += item.Size
+}
+
+func (g *PlanGroup) String() string {
+	return fmt.Sprintf("convert: %d | copy: %d | up-to-date: %d | size: %s", g.Convert, g.Copy, g.UpToDate, FormatBytes(g.Size))
+}
+
+func (plan *Plan) WriteText(w io.Writer) error {
+	var err error
+	printf := func(format string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	for _, item := range plan.Items {
+		action := item.Codec
+		if item.UpToDate {
+			action = "skip"
+		}
+		printf("%-7s <%s> -> <%s>", action, item.Input, item.Output)
+		if item.Profile != "" {
+			printf(" [%s]", item.Profile)
+		}
+		printf("\n")
+	}
+	for _, e := range plan.Errors {
+		printf("error   %s\n", e)
+	}
+
+	printf("\nGroups:\n")
+	for _, g := range plan.Groups {
+		printf("%s\n\t%v\n", g.Path, g)
+	}
+	printf("\nTotal: %v | error: %d\n", &plan.Total, len(plan.Errors))
+	return err
+}
+
+func (plan *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+//FormatBytes format n in binary units such as 1.5MiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
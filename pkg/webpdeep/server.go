@@ -0,0 +1,510 @@
+package webpdeep
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/internal/coder"
+	"github.com/mocukie/webpdeep/internal/component"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//ServerOptions of Server, encode defaults, workers, convert pattern and zip methods come from Converter
+type ServerOptions struct {
+	MaxBodySize  int64                        //bytes of request body, <= 0 for unlimited
+	MaxUnzipSize int64                        //total uncompressed bytes of uploaded zip entries, <= 0 for unlimited
+	Profiles     map[string]map[string]string //named sets of query parameters
+}
+
+//Server convert uploaded images and archives over HTTP
+//
+//	POST /convert      image in raw body or multipart file, returns WebP
+//	POST /convert/zip  zip in raw body or multipart file, returns zip with images converted
+//	GET  /healthz      status and worker usage
+//
+//Encode options are query parameters: profile, preset, quality, lossless, z, method, exact, near_lossless,
+//alpha_quality, sharp_yuv and meta (copy image metadata), parameters override the named profile.
+type Server struct {
+	opts   ServerOptions
+	conv   *Converter
+	config *component.Config
+	slots  chan struct{} //bounded encode concurrency shared by all requests
+	active int32
+	mux    *http.ServeMux
+}
+
+func NewServer(c *Converter, opts ServerOptions) (*Server, error) {
+	config, err := c.opts.config()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		opts:   opts,
+		conv:   c,
+		config: config,
+		slots:  make(chan struct{}, config.MaxGo),
+		mux:    http.NewServeMux(),
+	}
+	for name := range opts.Profiles {
+		if _, _, err = s.encodeOptions(url.Values{"profile": {name}}); err != nil {
+			return nil, errors.WithMessagef(err, "invalid profile <%s>", name)
+		}
+	}
+	s.mux.HandleFunc("/convert", s.handleConvert)
+	s.mux.HandleFunc("/convert/zip", s.handleZip)
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+//httpError is an error with response status
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, err: errors.Errorf(format, a...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var he *httpError
+	if errors.As(err, &he) {
+		status = he.status
+	}
+	http.Error(w, err.Error(), status)
+}
+
+//acquire wait for a free encode slot until request canceled
+func (s *Server) acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		atomic.AddInt32(&s.active, 1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) release() {
+	atomic.AddInt32(&s.active, -1)
+	<-s.slots
+}
+
+//encodeOptions merge profile and query parameters into encode options
+func (s *Server) encodeOptions(query url.Values) (*webp.EncodeOptions, bool, error) {
+	params := map[string]string{}
+	if name := query.Get("profile"); name != "" {
+		profile, ok := s.opts.Profiles[name]
+		if !ok {
+			return nil, false, badRequest("unknown profile: %s", name)
+		}
+		for k, v := range profile {
+			params[k] = v
+		}
+	}
+	for k := range query {
+		if k != "profile" {
+			params[k] = query.Get(k)
+		}
+	}
+
+	var (
+		opts = *s.conv.opts.Encode
+		meta = s.conv.opts.CopyImageMeta
+		err  error
+	)
+	_, hasPreset := params["preset"]
+	_, hasQuality := params["quality"]
+	if hasPreset || hasQuality {
+		preset, quality := webp.PresetDefault, opts.Quality
+		if v, ok := params["preset"]; ok {
+			if preset, err = ParsePreset(v); err != nil {
+				return nil, false, &httpError{status: http.StatusBadRequest, err: err}
+			}
+		}
+		if v, ok := params["quality"]; ok {
+			q, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				return nil, false, badRequest("invalid quality: %s", v)
+			}
+			quality = float32(q)
+		}
+		p, err := webp.NewEncOptionsByPreset(preset, quality)
+		if err != nil {
+			return nil, false, badRequest("invalid preset or quality: %v", err)
+		}
+		opts = *p
+	}
+
+	for k, v := range params {
+		switch k {
+		case "preset", "quality":
+		case "lossless":
+			opts.Lossless, err = strconv.ParseBool(v)
+			if err == nil && opts.Lossless && !hasQuality {
+				opts.Quality = webp.LosslessDefaultQuality
+			}
+		case "z":
+			var level int
+			if level, err = strconv.Atoi(v); err == nil {
+				err = opts.SetupLosslessPreset(level)
+			}
+		case "method":
+			opts.Method, err = strconv.Atoi(v)
+		case "exact":
+			opts.Exact, err = strconv.ParseBool(v)
+		case "near_lossless":
+			opts.NearLossless, err = strconv.Atoi(v)
+		case "alpha_quality":
+			opts.AlphaQuality, err = strconv.Atoi(v)
+		case "sharp_yuv":
+			opts.UseSharpYUV, err = strconv.ParseBool(v)
+		case "meta":
+			meta, err = strconv.ParseBool(v)
+		default:
+			return nil, false, badRequest("unknown parameter: %s", k)
+		}
+		if err != nil {
+			return nil, false, badRequest("invalid %s: %s", k, v)
+		}
+	}
+	if err = opts.Validate(); err != nil {
+		return nil, false, badRequest("invalid encode options: %v", err)
+	}
+	return &opts, meta, nil
+}
+
+//readUpload return raw body, or content of the first file part of multipart body
+func (s *Server) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, string, error) {
+	if s.opts.MaxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.opts.MaxBodySize)
+	}
+	var (
+		body io.Reader = r.Body
+		name string
+	)
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, "", badRequest("invalid multipart body: %v", err)
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, "", badRequest("no file in multipart body")
+			} else if err != nil {
+				return nil, "", uploadError(err)
+			}
+			if part.FileName() != "" {
+				body, name = part, part.FileName()
+				break
+			}
+		}
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", uploadError(err)
+	}
+	if len(data) == 0 {
+		return nil, "", badRequest("empty body")
+	}
+	return data, name, nil
+}
+
+func uploadError(err error) error {
+	if strings.Contains(err.Error(), "request body too large") {
+		return &httpError{status: http.StatusRequestEntityTooLarge, err: err}
+	}
+	return badRequest("can not read body: %v", err)
+}
+
+//encode convert data with a free slot
+func (s *Server) encode(ctx context.Context, data []byte, opts *webp.EncodeOptions, meta bool) ([]byte, *coder.WebP, []error, error) {
+	if err := s.acquire(ctx); err != nil {
+		return nil, nil, nil, err
+	}
+	defer s.release()
+	return s.convert(data, opts, meta)
+}
+
+//convert encode data, caller must hold a slot
+func (s *Server) convert(data []byte, opts *webp.EncodeOptions, meta bool) ([]byte, *coder.WebP, []error, error) {
+	var (
+		codec = &coder.WebP{Opts: opts, CopyMeta: meta, Meta: s.config.MetaPolicy, Stamp: s.config.Stamp, CheckImage: s.conv.opts.CheckImage}
+		out   bytes.Buffer
+	)
+	err, warnings := codec.Convert(bytes.NewReader(data), &out)
+	return out.Bytes(), codec, warnings, err
+}
+
+func (s *Server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts, meta, err := s.encodeOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, _, err := s.readUpload(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	out, codec, warnings, err := s.encode(r.Context(), data, opts, meta)
+	if err != nil {
+		writeError(w, &httpError{status: http.StatusUnprocessableEntity, err: err})
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "image/webp")
+	h.Set("Content-Length", strconv.Itoa(len(out)))
+	h.Set("X-Image-Width", strconv.Itoa(codec.Width))
+	h.Set("X-Image-Height", strconv.Itoa(codec.Height))
+	h.Set("X-Webpdeep-Warnings", strconv.Itoa(len(warnings)))
+	_, _ = w.Write(out)
+}
+
+type zipResult struct {
+	name   string
+	data   []byte
+	method uint16
+	err    error
+}
+
+func (s *Server) handleZip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts, meta, err := s.encodeOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, name, err := s.readUpload(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		writeError(w, badRequest("invalid zip: %v", err))
+		return
+	}
+
+	results, err := s.planZip(reader)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	//entries are decompressed by workers holding slots, so memory is bounded by workers as well as encoding
+	var (
+		wg   sync.WaitGroup
+		jobs = make(chan int)
+	)
+	for n := 0; n < cap(s.slots) && n < len(reader.File); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s.convertZipEntry(r.Context(), reader.File[i], &results[i], opts, meta)
+			}
+		}()
+	}
+	for i, entry := range reader.File {
+		if !entry.FileInfo().IsDir() {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err = r.Context().Err(); err != nil {
+		return
+	}
+	for i := range results {
+		var he *httpError
+		if errors.As(results[i].err, &he) {
+			writeError(w, he)
+			return
+		}
+	}
+
+	var (
+		buf    bytes.Buffer
+		zw     = zip.NewWriter(&buf)
+		level  = flate.DefaultCompression
+		failed int
+	)
+	//entries are written one by one, so compressor can read level of current entry
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	for i, entry := range reader.File {
+		res := &results[i]
+		if res.err != nil {
+			failed++
+		}
+		if res.data == nil && !entry.FileInfo().IsDir() {
+			continue
+		}
+		fh := entry.FileHeader
+		fh.Name = res.name
+		if entry.FileInfo().IsDir() {
+			fh.Name = entry.Name
+		}
+		fh.Method, level = s.config.ZipMethodOf(fh.Name)
+		fh.CompressedSize64, fh.UncompressedSize64, fh.CRC32 = 0, 0, 0
+		ew, err := zw.CreateHeader(&fh)
+		if err == nil {
+			_, err = ew.Write(res.data)
+		}
+		if err != nil {
+			writeError(w, errors.WithStack(err))
+			return
+		}
+	}
+	if err = zw.Close(); err != nil {
+		writeError(w, errors.WithStack(err))
+		return
+	}
+
+	if name == "" {
+		name = "output.zip"
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/zip")
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	h.Set("X-Webpdeep-Failed", strconv.Itoa(failed))
+	_, _ = w.Write(buf.Bytes())
+}
+
+//planZip check sizes and output names of entries, and return results with names of kept entries
+func (s *Server) planZip(reader *zip.Reader) ([]zipResult, error) {
+	var (
+		results = make([]zipResult, len(reader.File))
+		names   = make(map[string]string, len(reader.File))
+		total   uint64
+	)
+	for i, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		total += entry.UncompressedSize64
+		if limit := s.opts.MaxUnzipSize; limit > 0 && (entry.UncompressedSize64 > uint64(limit) || total > uint64(limit)) {
+			return nil, &httpError{status: http.StatusRequestEntityTooLarge, err: errors.Errorf("zip entries exceed %d bytes uncompressed", limit)}
+		}
+		results[i].name = entry.Name
+		out := entry.Name
+		if s.config.ConvertMatch(entry.Name, false) {
+			out = entry.Name[:len(entry.Name)-len(path.Ext(entry.Name))] + ".webp"
+		}
+		if other, ok := names[out]; ok {
+			return nil, badRequest("zip entries <%s> and <%s> both output <%s>", other, entry.Name, out)
+		}
+		names[out] = entry.Name
+	}
+	return results, nil
+}
+
+//convertZipEntry read entry into res and encode it if matched, the original entry is kept if encoding failed
+func (s *Server) convertZipEntry(ctx context.Context, entry *zip.File, res *zipResult, opts *webp.EncodeOptions, meta bool) {
+	if res.err = s.acquire(ctx); res.err != nil {
+		return
+	}
+	defer s.release()
+
+	raw, err := s.readZipEntry(entry)
+	if err != nil {
+		res.err = err
+		return
+	}
+	res.data = raw
+	if !s.config.ConvertMatch(entry.Name, false) {
+		return
+	}
+	out, _, _, err := s.convert(raw, opts, meta)
+	if err != nil {
+		//keep the original entry
+		res.err = err
+		return
+	}
+	res.name = entry.Name[:len(entry.Name)-len(path.Ext(entry.Name))] + ".webp"
+	res.data = out
+}
+
+//readZipEntry read entry no more than its declared size and MaxUnzipSize
+func (s *Server) readZipEntry(entry *zip.File) ([]byte, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rc.Close()
+	limit := entry.UncompressedSize64
+	if max := s.opts.MaxUnzipSize; max > 0 && uint64(max) < limit {
+		limit = uint64(max)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(rc, int64(limit)+1))
+	if err == nil && uint64(len(data)) > limit {
+		return nil, &httpError{status: http.StatusRequestEntityTooLarge, err: errors.Errorf("zip entry <%s> larger than declared", entry.Name)}
+	}
+	return data, errors.WithStack(err)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "ok",
+		"workers": cap(s.slots),
+		"active":  atomic.LoadInt32(&s.active),
+		"encoder": fmt.Sprintf("v%v", webp.EncoderVersion()),
+	})
+}
+
+//ParsePreset return preset by name: default, photo, picture, drawing, icon or text
+func ParsePreset(name string) (webp.EncodePreset, error) {
+	switch name {
+	case "default":
+		return webp.PresetDefault, nil
+	case "photo":
+		return webp.PresetPhoto, nil
+	case "picture":
+		return webp.PresetPicture, nil
+	case "drawing":
+		return webp.PresetDrawing, nil
+	case "icon":
+		return webp.PresetIcon, nil
+	case "text":
+		return webp.PresetText, nil
+	}
+	return -1, errors.New("invalid preset: " + name)
+}
@@ -0,0 +1,112 @@
+package webpdeep
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testZip(t *testing.T, entries map[string][]byte) []byte {
+	var (
+		buf   bytes.Buffer
+		zw    = zip.NewWriter(&buf)
+		names []string
+	)
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err == nil {
+			_, err = w.Write(entries[name])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testServer(t *testing.T, opts ServerOptions) *httptest.Server {
+	conv, err := New(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(conv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(s)
+}
+
+func TestServerZip(t *testing.T) {
+	img := testPNG(t)
+	ts := testServer(t, ServerOptions{MaxUnzipSize: 1 << 20})
+	defer ts.Close()
+
+	cases := []struct {
+		name    string
+		entries map[string][]byte
+		status  int
+		outputs []string
+	}{
+		{"convert", map[string][]byte{"a.png": img, "dir/b.png": img, "note.txt": []byte("x")}, http.StatusOK,
+			[]string{"a.webp", "dir/b.webp", "note.txt"}},
+		{"duplicate output", map[string][]byte{"a.png": img, "a.jpg": img}, http.StatusBadRequest, nil},
+		{"duplicate copied output", map[string][]byte{"a.png": img, "a.webp": img}, http.StatusBadRequest, nil},
+		{"too large", map[string][]byte{"a.bin": make([]byte, 1<<20+1)}, http.StatusRequestEntityTooLarge, nil},
+		{"too large in total", map[string][]byte{"a.bin": make([]byte, 1<<19), "b.bin": make([]byte, 1<<19+1)},
+			http.StatusRequestEntityTooLarge, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL+"/convert/zip", "application/zip", bytes.NewReader(testZip(t, c.entries)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != c.status {
+				t.Fatalf("got status %d %s, want %d", resp.StatusCode, body, c.status)
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+			zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			sort.Strings(names)
+			if len(names) != len(c.outputs) {
+				t.Fatalf("got entries %v, want %v", names, c.outputs)
+			}
+			for i := range names {
+				if names[i] != c.outputs[i] {
+					t.Fatalf("got entries %v, want %v", names, c.outputs)
+				}
+			}
+		})
+	}
+}
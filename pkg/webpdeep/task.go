@@ -0,0 +1,150 @@
+package webpdeep
+
+import (
+	"github.com/mocukie/webpdeep/internal/component"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+//Task is a resolved source and output of Converter
+type Task struct {
+	Src     string   //source image, archive or directory, empty in list mode
+	Sources []string //listed inputs in list mode
+	Base    string   //listed inputs map to output relative to Base, also root of in place backup
+	Dest    string
+	OutDir  string //directory holding outputs
+	LogPath string //file inside source skipped by watch mode, optional
+	config  *component.Config
+}
+
+//NewTask resolve output of src, empty dst is derived if possible, or src itself in place mode
+func (c *Converter) NewTask(src, dst string) (*Task, error) {
+	conf, err := c.opts.config()
+	if err != nil {
+		return nil, err
+	}
+	if src == "" {
+		return nil, errors.New("input not specify")
+	}
+	if src == iox.StdPath || dst == iox.StdPath {
+		return nil, errors.New("standard input and output are only supported by ConvertFile")
+	}
+	if conf.InPlace {
+		if dst != "" {
+			return nil, errors.New("in place can not be used with output")
+		}
+		return newInPlaceTask(conf, src, nil, "", "")
+	}
+
+	conf.Src, conf.Dest = filepath.Clean(src), dst
+	stat, err := os.Stat(conf.Src)
+	var (
+		isDir     = err == nil && stat.IsDir()
+		isArchive = err == nil && !isDir && conf.ArchiveMatch(conf.Src, true)
+		packDir   = isDir && conf.Container == component.ContainerZip && !conf.Recursively
+		unpackZip = isArchive && conf.Container == component.ContainerDir
+	)
+	if err == nil && !isDir && !isArchive && conf.Container != component.ContainerAuto {
+		return nil, errors.New("container option requires directory or archive input")
+	}
+
+	if conf.Watch && (!isDir || conf.Container != component.ContainerAuto) {
+		return nil, errors.New("watch mode requires directory input and auto container")
+	}
+
+	if conf.Dest == "" {
+		switch {
+		case packDir:
+			conf.Dest = conf.Src + conf.PackExt
+		case unpackZip:
+			conf.Dest = conf.Src[:len(conf.Src)-len(filepath.Ext(conf.Src))]
+		case err == nil && !isDir:
+			conf.Dest = conf.Src[:len(conf.Src)-len(filepath.Ext(conf.Src))] + ".webp"
+		default:
+			return nil, errors.New("output not specify")
+		}
+	}
+	conf.Dest = filepath.Clean(conf.Dest)
+
+	t := &Task{Src: conf.Src, Dest: conf.Dest, OutDir: filepath.Dir(conf.Dest), config: conf}
+	if (isDir && !packDir) || unpackZip {
+		t.OutDir = conf.Dest
+	}
+	return t, nil
+}
+
+//NewListTask resolve outputs of inputs listed in sources and file filesFrom ("-" for stdin), mapped relative to base
+func (c *Converter) NewListTask(base string, sources []string, filesFrom, dst string) (*Task, error) {
+	conf, err := c.opts.config()
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		base = "."
+	}
+	if conf.InPlace {
+		if dst != "" {
+			return nil, errors.New("in place can not be used with output")
+		}
+		return newInPlaceTask(conf, "", sources, filesFrom, base)
+	}
+	if conf.Watch {
+		return nil, errors.New("watch mode requires single directory input")
+	}
+	if dst == "" {
+		return nil, errors.New("output not specify")
+	}
+
+	conf.Sources, conf.FilesFrom = sources, filesFrom
+	conf.Dest = filepath.Clean(dst)
+	conf.Base = filepath.Clean(base)
+	return &Task{Sources: sources, Base: conf.Base, Dest: conf.Dest, OutDir: conf.Dest, config: conf}, nil
+}
+
+//newInPlaceTask resolve task writing output next to source, copy pattern is ignored
+func newInPlaceTask(conf *component.Config, src string, sources []string, filesFrom, base string) (*Task, error) {
+	if conf.Watch || conf.Container != component.ContainerAuto {
+		return nil, errors.New("in place can not be used with watch or container option")
+	}
+	if conf.Original == component.OriginalBackup {
+		if conf.BackupDir == "" {
+			return nil, errors.New("backup directory not specify")
+		}
+		conf.BackupDir = filepath.Clean(conf.BackupDir)
+	}
+	conf.CopyMatch = nil
+
+	if src == "" {
+		conf.Sources, conf.FilesFrom = sources, filesFrom
+		conf.Base = filepath.Clean(base)
+		conf.Dest = conf.Base
+	} else {
+		conf.Src = filepath.Clean(src)
+		stat, err := os.Stat(conf.Src)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		switch {
+		case stat.IsDir():
+			conf.Base, conf.Dest = conf.Src, conf.Src
+		case conf.ArchiveMatch(conf.Src, true):
+			conf.Base, conf.Dest = filepath.Dir(conf.Src), conf.Src
+		default:
+			conf.Base = filepath.Dir(conf.Src)
+			conf.Dest = conf.Src[:len(conf.Src)-len(filepath.Ext(conf.Src))] + ".webp"
+		}
+	}
+	return &Task{Src: conf.Src, Sources: conf.Sources, Base: conf.Base, Dest: conf.Dest, OutDir: conf.Base, config: conf}, nil
+}
+
+//session build session of a copy of task config
+func (t *Task) session(dedup *component.DedupCache, dryRun bool) *component.Session {
+	conf := *t.config
+	conf.LogPath = t.LogPath
+	conf.Dedup = dedup
+	conf.DryRun = dryRun
+	conf.JobQueue = nil
+	return component.NewSession(&conf)
+}
@@ -0,0 +1,252 @@
+package webpdeep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mocukie/webp-go/webp"
+	"github.com/mocukie/webpdeep/internal/iox"
+	"github.com/mocukie/webpdeep/pkg/imagex"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type IssueKind string
+
+const (
+	IssueScan        IssueKind = "scan"        //source could not be scanned
+	IssueSource      IssueKind = "source"      //source could not be read or decoded
+	IssueMissing     IssueKind = "missing"     //output of source not found
+	IssueUndecodable IssueKind = "undecodable" //output is not a decodable webp
+	IssueDimension   IssueKind = "dimension"   //output width or height differs from source
+	IssuePixel       IssueKind = "pixel"       //lossless output differs from source
+	IssueQuality     IssueKind = "quality"     //PSNR of lossy output is below Options.MinPSNR
+	IssueOrphan      IssueKind = "orphan"      //output not made by any source
+)
+
+//VerifyIssue is a problem found in outputs of a task
+type VerifyIssue struct {
+	Kind   IssueKind
+	Input  string
+	Output string
+	Err    error
+}
+
+func (i *VerifyIssue) String() string {
+	switch {
+	case i.Input != "":
+		return fmt.Sprintf("%-11s <%s> -> <%s>: %v", i.Kind, i.Input, i.Output, i.Err)
+	case i.Output != "":
+		return fmt.Sprintf("%-11s <%s>: %v", i.Kind, i.Output, i.Err)
+	}
+	return fmt.Sprintf("%-11s %v", i.Kind, i.Err)
+}
+
+//VerifyReport is the result of Verify
+type VerifyReport struct {
+	Checked int
+	Issues  []VerifyIssue
+}
+
+//Verify decode existing outputs of task and compare them with sources, nothing is written.
+//Sources are mapped to outputs as RunTask does, archive entries included, outputs made by no source are orphans.
+//Lossless outputs must equal sources in pixels, lossy ones are checked by Options.MinPSNR.
+func (c *Converter) Verify(ctx context.Context, t *Task) (*VerifyReport, error) {
+	plan, err := c.Plan(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		report = &VerifyReport{Issues: []VerifyIssue{}}
+		nf     = newNestedFiles()
+		items  = make(chan *PlanItem)
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	defer nf.Close()
+	for _, e := range plan.Errors {
+		report.Issues = append(report.Issues, VerifyIssue{Kind: IssueScan, Err: errors.New(e)})
+	}
+
+	for i := 0; i < t.config.MaxGo; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				issue := c.verifyItem(nf, item)
+				mu.Lock()
+				report.Checked++
+				if issue != nil {
+					report.Issues = append(report.Issues, *issue)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+Loop:
+	for i := range plan.Items {
+		select {
+		case items <- &plan.Items[i]:
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(items)
+	wg.Wait()
+	if err = ctx.Err(); err != nil {
+		return report, err
+	}
+
+	if !t.config.InPlace {
+		report.Issues = append(report.Issues, orphans(t, plan, nf)...)
+	}
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		a, b := &report.Issues[i], &report.Issues[j]
+		if a.Input != b.Input {
+			return a.Input < b.Input
+		}
+		return a.Output < b.Output
+	})
+	return report, nil
+}
+
+func (c *Converter) verifyItem(nf *nestedFiles, item *PlanItem) *VerifyIssue {
+	issue := func(kind IssueKind, err error) *VerifyIssue {
+		return &VerifyIssue{Kind: kind, Input: item.Input, Output: item.Output, Err: err}
+	}
+	if item.Codec != CodecConvert {
+		if _, err := nf.size(item.Output); err != nil {
+			return issue(IssueMissing, err)
+		}
+		return nil
+	}
+
+	out, err := nf.read(item.Output)
+	if err != nil {
+		return issue(IssueMissing, err)
+	}
+	outImg, err := webp.DecodeSlice(out, webpDecodeOpts)
+	if err != nil {
+		return issue(IssueUndecodable, errors.WithStack(err))
+	}
+	ow, oh := outImg.Bounds().Dx(), outImg.Bounds().Dy()
+
+	in, err := nf.read(item.Input)
+	if err != nil {
+		return issue(IssueSource, err)
+	}
+	inImg, _, _, err := imagex.Decode(bytes.NewReader(in))
+	if err != nil {
+		return issue(IssueSource, errors.WithStack(err))
+	}
+	iw, ih := inImg.Bounds().Dx(), inImg.Bounds().Dy()
+	if iw != ow || ih != oh {
+		return issue(IssueDimension, errors.Errorf("source is %dx%d, output is %dx%d", iw, ih, ow, oh))
+	}
+
+	//bitstream tells how the output was encoded, options are the fallback
+	lossless := c.opts.Encode.Lossless
+	if f, err := webp.GetBitstreamFeatures(out); err == nil {
+		lossless = f.Format == webp.FormatLossless
+	}
+	if lossless {
+		if !imagex.IsImageEqual(inImg, outImg) {
+			return issue(IssuePixel, errors.New("lossless output is not equal to source"))
+		}
+	} else if c.opts.MinPSNR > 0 {
+		if psnr := imagex.PSNR(inImg, outImg); psnr < c.opts.MinPSNR {
+			return issue(IssueQuality, errors.Errorf("PSNR %.2fdB is below %.2fdB", psnr, c.opts.MinPSNR))
+		}
+	}
+	return nil
+}
+
+//orphans return files and archive entries under destination of task not made by any source,
+//log files of webpdeep are ignored
+func orphans(t *Task, plan *Plan, nf *nestedFiles) []VerifyIssue {
+	var (
+		issues   []VerifyIssue
+		expected = make(map[string]bool, len(plan.Items))
+		archives = map[string]bool{}
+	)
+	for _, item := range plan.Items {
+		expected[item.Output] = true
+		if idx := strings.LastIndex(item.Output, iox.NestSeparator); idx != -1 {
+			archives[item.Output[:idx]] = true
+		}
+	}
+	orphan := func(pathname string, err error) {
+		issues = append(issues, VerifyIssue{Kind: IssueOrphan, Output: pathname, Err: err})
+	}
+	check := func(pathname string) {
+		switch {
+		case expected[pathname]:
+		case archives[pathname]:
+			a, err := nf.archive(pathname)
+			if err != nil {
+				orphan(pathname, err)
+				return
+			}
+			for _, entry := range a.zip.File {
+				name := pathname + iox.NestSeparator + entry.Name
+				if !entry.Mode().IsDir() && !expected[name] {
+					orphan(name, errors.New("not made by any source"))
+				}
+			}
+		default:
+			if ok, _ := filepath.Match("webpdeep-*.log*", filepath.Base(pathname)); !ok {
+				orphan(pathname, errors.New("not made by any source"))
+			}
+		}
+	}
+
+	info, err := os.Stat(t.Dest)
+	if err != nil {
+		return issues
+	}
+	if !info.IsDir() {
+		if archives[t.Dest] {
+			check(t.Dest)
+		}
+		return issues
+	}
+	_ = filepath.Walk(t.Dest, func(pathname string, info os.FileInfo, err error) error {
+		if err != nil {
+			orphan(pathname, errors.WithStack(err))
+		} else if info.Mode().IsRegular() {
+			check(pathname)
+		}
+		return nil
+	})
+	return issues
+}
+
+//Sizes pair inputs of task with their existing outputs, InBytes and OutBytes are file sizes
+//and Err is set if the output is missing, nothing is written
+func (c *Converter) Sizes(ctx context.Context, t *Task) ([]*Result, error) {
+	plan, err := c.Plan(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	nf := newNestedFiles()
+	defer nf.Close()
+	results := make([]*Result, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		r := &Result{Input: item.Input, Output: item.Output, Codec: item.Codec, Profile: item.Profile, Size: item.Size}
+		if r.InBytes, r.Err = nf.size(item.Input); r.Err == nil {
+			r.OutBytes, r.Err = nf.size(item.Output)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+var webpDecodeOpts = webp.NewDecOptions()
+
+func init() {
+	webpDecodeOpts.ImageType = webp.TypeNRGBA
+}
@@ -0,0 +1,150 @@
+package zipx
+
+import (
+	"archive/zip"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type charset struct {
+	name string
+	enc  encoding.Encoding
+}
+
+//candidates of auto detection, earlier one wins when scores are equal
+var charsets = []charset{
+	{"shift_jis", japanese.ShiftJIS},
+	{"gbk", simplifiedchinese.GBK},
+	{"big5", traditionalchinese.Big5},
+	{"euc-kr", korean.EUCKR},
+	{"cp437", charmap.CodePage437},
+}
+
+//LookupCharset return the legacy encoding by name, nil for "auto"
+func LookupCharset(name string) (encoding.Encoding, error) {
+	name = strings.ToLower(name)
+	if name == "auto" {
+		return nil, nil
+	}
+	for _, cs := range charsets {
+		if cs.name == name {
+			return cs.enc, nil
+		}
+	}
+	return nil, errors.New("unsupported charset: " + name)
+}
+
+//NameDecoder convert non-utf8 entry names of an archive to utf8
+type NameDecoder struct {
+	enc  encoding.Encoding
+	auto bool
+}
+
+//NewNameDecoder create decoder for entries in files, detect charset from their names if enc is nil
+func NewNameDecoder(files []*zip.File, enc encoding.Encoding) *NameDecoder {
+	var auto = enc == nil
+	if auto {
+		var names []string
+		for _, f := range files {
+			if name, nonUTF8 := DetectZipUTF8Path(&f.FileHeader); nonUTF8 {
+				names = append(names, name)
+			}
+		}
+		enc = DetectCharset(names)
+	}
+	return &NameDecoder{enc: enc, auto: auto}
+}
+
+//Name return utf8 name of fh, Info-ZIP Unicode Path takes precedence over legacy charset
+func (d *NameDecoder) Name(fh *zip.FileHeader) string {
+	name, nonUTF8 := DetectZipUTF8Path(fh)
+	if !nonUTF8 || d.enc == nil || (d.auto && utf8.ValidString(name)) {
+		return name
+	}
+	if s, err := d.enc.NewDecoder().String(name); err == nil {
+		return s
+	}
+	return name
+}
+
+//DetectCharset guess legacy charset of names, return nil if names look like utf8 already
+func DetectCharset(names []string) encoding.Encoding {
+	var allUTF8 = true
+	for _, name := range names {
+		if !utf8.ValidString(name) {
+			allUTF8 = false
+			break
+		}
+	}
+	if allUTF8 {
+		return nil
+	}
+
+	var (
+		best      encoding.Encoding
+		bestScore = 0
+	)
+	for _, cs := range charsets {
+		score, dec := 0, cs.enc.NewDecoder()
+		for _, name := range names {
+			s, err := dec.String(name)
+			if err != nil {
+				score -= 100
+				continue
+			}
+			score += scoreText(s)
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = cs.enc, score
+		}
+	}
+	return best
+}
+
+//frequently used hangul syllables
+const commonHangul = "이다의는에을를하가고지로기서한사도리자어대인수것나아있해보시우일들그라상정전부주게요소제스장적학원" +
+	"면국구화성거위무미결간여경마세계관동방문신개조회공실물생각만내편중모두었습니했던않까며후말또때년저러더된될음과와은으께" +
+	"권표목차그림집사진작품영상본외전특별부록"
+
+//scoreText rate how natural a decoded file name looks
+func scoreText(s string) int {
+	score := 0
+	for _, r := range s {
+		switch {
+		case r == utf8.RuneError:
+			score -= 20
+		case r < utf8.RuneSelf:
+			if unicode.IsControl(r) {
+				score -= 5
+			}
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana) && r < 0xff00:
+			score += 3
+		case unicode.Is(unicode.Hangul, r) && r >= 0xac00:
+			//mis-decoded CJK bytes produce valid but rarely used syllables
+			if strings.ContainsRune(commonHangul, r) {
+				score += 3
+			} else {
+				score += 1
+			}
+		case r >= 0x4e00 && r <= 0x9fff: //CJK Unified Ideographs
+			score += 2
+		case r >= 0x3000 && r <= 0x303f, r >= 0xff01 && r <= 0xff5e: //CJK punctuation, full width forms
+			score += 1
+		case r >= 0xff61 && r <= 0xff9f: //half width katakana, common in mis-decoded text
+			score -= 1
+		case r >= 0xe000 && r <= 0xf8ff, unicode.IsControl(r): //private use area, control
+			score -= 10
+		default:
+			score -= 1
+		}
+	}
+	return score
+}
@@ -0,0 +1,92 @@
+package zipx
+
+import (
+	"archive/zip"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"testing"
+)
+
+func encodeNames(t *testing.T, enc encoding.Encoding, names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		s, err := enc.NewEncoder().String(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = s
+	}
+	return out
+}
+
+func TestDetectCharset(t *testing.T) {
+	cases := []struct {
+		name  string
+		enc   encoding.Encoding
+		names []string
+	}{
+		{"shift_jis", japanese.ShiftJIS, []string{"表紙.jpg", "第一話/あらすじ.png", "カラー口絵.png"}},
+		{"gbk", simplifiedchinese.GBK, []string{"封面.jpg", "第一章/插图.png", "简体中文说明.txt"}},
+		{"big5", traditionalchinese.Big5, []string{"封面.jpg", "第一章/插圖.png", "繁體中文說明.txt"}},
+		{"euc-kr", korean.EUCKR, []string{"표지.jpg", "제1화/그림.png", "작품 설명.txt"}},
+		{"cp437", charmap.CodePage437, []string{"Ünïcödé.png", "façade.jpg"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := DetectCharset(encodeNames(t, c.enc, c.names)); got != c.enc {
+				t.Fatalf("got %v, want %v", got, c.enc)
+			}
+		})
+	}
+	if got := DetectCharset([]string{"plain.png", "中文.png"}); got != nil {
+		t.Fatalf("utf8 names: got %v, want nil", got)
+	}
+}
+
+func TestLookupCharset(t *testing.T) {
+	cases := []struct {
+		name string
+		want encoding.Encoding
+		err  bool
+	}{
+		{"auto", nil, false},
+		{"Shift_JIS", japanese.ShiftJIS, false},
+		{"GBK", simplifiedchinese.GBK, false},
+		{"cp437", charmap.CodePage437, false},
+		{"utf-16", nil, true},
+	}
+	for _, c := range cases {
+		got, err := LookupCharset(c.name)
+		if (err != nil) != c.err || got != c.want {
+			t.Errorf("%s: got %v %v, want %v error %v", c.name, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestNameDecoder(t *testing.T) {
+	sjis := encodeNames(t, japanese.ShiftJIS, []string{"表紙.jpg"})[0]
+	cases := []struct {
+		name string
+		fh   zip.FileHeader
+		enc  encoding.Encoding
+		want string
+	}{
+		{"utf8 flag", zip.FileHeader{Name: "表紙.jpg"}, japanese.ShiftJIS, "表紙.jpg"},
+		{"given charset", zip.FileHeader{Name: sjis, NonUTF8: true}, japanese.ShiftJIS, "表紙.jpg"},
+		{"detected charset", zip.FileHeader{Name: sjis, NonUTF8: true}, nil, "表紙.jpg"},
+		{"ascii", zip.FileHeader{Name: "cover.jpg", NonUTF8: true}, nil, "cover.jpg"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fh := c.fh
+			d := NewNameDecoder([]*zip.File{{FileHeader: fh}}, c.enc)
+			if got := d.Name(&fh); got != c.want {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
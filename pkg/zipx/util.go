@@ -55,3 +55,30 @@ func DetectZipUTF8Path(fh *zip.FileHeader) (string, bool) {
 	}
 	return name, nonUtf8
 }
+
+var unsafeExtraTags = map[uint16]bool{
+	0x0001: true, //zip64 extended information, rewritten by zip.Writer
+	0x0017: true, //strong encryption header
+	0x5455: true, //extended timestamp, rewritten by zip.Writer
+	0x6375: true, //Info-ZIP Unicode Comment
+	0x7075: true, //Info-ZIP Unicode Path, name is always written as utf8
+	0x9901: true, //AE-x encryption
+}
+
+//FilterExtra drop extra fields which become invalid after entry rewritten
+func FilterExtra(extra []byte) []byte {
+	var out []byte
+	for pos := 0; pos+4 <= len(extra); {
+		tag := binary.LittleEndian.Uint16(extra[pos : pos+2])
+		size := int(binary.LittleEndian.Uint16(extra[pos+2 : pos+4]))
+		end := pos + 4 + size
+		if end > len(extra) {
+			break
+		}
+		if !unsafeExtraTags[tag] {
+			out = append(out, extra[pos:end]...)
+		}
+		pos = end
+	}
+	return out
+}